@@ -5,7 +5,6 @@ import (
 	"net/http"
 	"simplewebapi.moviedb/internal/data"
 	"simplewebapi.moviedb/internal/validator"
-	"time"
 )
 
 type CredentialsInput struct {
@@ -30,7 +29,7 @@ func (app *application) authenticationHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	user, err := app.repos.Users.GetByEmail(input.Email)
+	user, err := app.repos.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -51,7 +50,12 @@ func (app *application) authenticationHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	token, err := app.repos.Tokens.New(user.ID, 1*time.Hour, data.ScopeAuthentication)
+	// New replaces the previous token with two statements, which must not be torn apart.
+	var token *data.Token
+	err = app.repos.WithTx(r.Context(), func(tx data.Repo) error {
+		token, err = tx.Tokens.New(r.Context(), user.ID, app.config.tokens.authenticationTTL, data.ScopeAuthentication)
+		return err
+	})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestAuthentication(t *testing.T) {
+	tests := []struct {
+		name string
+		req  testRequest
+	}{
+		{name: "CreateToken", req: testRequest{method: "POST", path: "/v1/users/authentication",
+			body: `{"email": "alice@example.com", "password": "pa55word"}`}},
+		{name: "WrongPassword", req: testRequest{method: "POST", path: "/v1/users/authentication",
+			body: `{"email": "alice@example.com", "password": "wrong-password"}`}},
+		{name: "UnknownEmail", req: testRequest{method: "POST", path: "/v1/users/authentication",
+			body: `{"email": "bob@example.com", "password": "pa55word"}`}},
+		{name: "MissingPassword", req: testRequest{method: "POST", path: "/v1/users/authentication",
+			body: `{"email": "alice@example.com"}`}},
+		{name: "BadJSON", req: testRequest{method: "POST", path: "/v1/users/authentication", body: `"alice@example.com"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.seedUser(t, "Alice", "alice@example.com")
+			checkGolden(t, app.do(t, tt.req))
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"log/slog"
+	"simplewebapi.moviedb/internal/data"
+	"time"
+)
+
+// newMovieCache returns the movie cache configured by the cache settings, or nil when
+// cache.size is zero. With cache.notify, its invalidations are announced through db.
+func newMovieCache(cfg config, db *sql.DB, logger *slog.Logger) *data.MovieCache {
+	if cfg.cache.size == 0 {
+		return nil
+	}
+	c := data.NewMovieCache(cfg.cache.size, cfg.cache.ttl)
+	if cfg.cache.notify {
+		c.PublishTo(db, cfg.db.timeouts.Write, func(err error) {
+			logger.Warn("failed to announce a movie cache invalidation, other instances serve stale movies until cache.ttl", "error", err)
+		})
+	}
+	return c
+}
+
+// listenMovieCache applies the invalidations announced by the other instances until
+// done is closed.
+func (app *application) listenMovieCache(done <-chan struct{}) {
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			app.logger.Warn("movie cache listener is disconnected, invalidations of other instances are missed", "error", err)
+		case pq.ListenerEventReconnected:
+			app.logger.Info("movie cache listener reconnected, the cache was dropped")
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(data.MovieCacheChannel)
+	if err != nil {
+		app.logger.Error("failed to listen for movie cache invalidations", "error", err)
+		return
+	}
+	app.movieCache.Listen(listener.Notify, done)
+}
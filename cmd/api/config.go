@@ -0,0 +1,564 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"simplewebapi.moviedb/internal/configfile"
+	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/validator"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "MOVIE_API_"
+
+type config struct {
+	port      int
+	adminPort int
+	env       string
+	db        struct {
+		driver       string
+		dsn          string
+		maxOpenConns int
+		maxIdleConns int
+		maxIdleTime  time.Duration
+		timeouts     data.Timeouts
+		lockTimeout  time.Duration
+		txAttempts   int
+
+		slowQueryThreshold time.Duration
+
+		replicaDSNs          []string
+		replicaCheckInterval time.Duration
+		readYourWritesWindow time.Duration
+	}
+	server struct {
+		idleTimeout     time.Duration
+		readTimeout     time.Duration
+		writeTimeout    time.Duration
+		shutdownTimeout time.Duration
+	}
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+	cors struct {
+		trustedOrigins   []string
+		allowedMethods   []string
+		allowedHeaders   []string
+		exposedHeaders   []string
+		allowCredentials bool
+		maxAge           time.Duration
+	}
+	tokens struct {
+		authenticationTTL time.Duration
+		activationTTL     time.Duration
+	}
+	users struct {
+		bcryptCost int
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	cache struct {
+		size   int
+		ttl    time.Duration
+		notify bool
+	}
+	httpCache struct {
+		maxAge time.Duration
+		size   int
+		ttl    time.Duration
+	}
+	log struct {
+		format string
+		level  string
+	}
+	trace struct {
+		exporter     string
+		file         string
+		otlpEndpoint string
+	}
+	health struct {
+		timeout       time.Duration
+		shutdownDelay time.Duration
+	}
+	errorFormat string
+	autoMigrate bool
+}
+
+func defaultConfig() config {
+	var cfg config
+	cfg.port = 4000
+	cfg.adminPort = 4001
+	cfg.env = "development"
+
+	cfg.db.driver = "postgres"
+	cfg.db.maxOpenConns = 25
+	cfg.db.maxIdleConns = 25
+	cfg.db.maxIdleTime = 15 * time.Minute
+	cfg.db.timeouts = data.DefaultTimeouts
+	cfg.db.lockTimeout = time.Second
+	cfg.db.txAttempts = data.DefaultTxAttempts
+	cfg.db.slowQueryThreshold = 200 * time.Millisecond
+	cfg.db.replicaCheckInterval = 5 * time.Second
+	cfg.db.readYourWritesWindow = 5 * time.Second
+
+	cfg.server.idleTimeout = time.Minute
+	cfg.server.readTimeout = 10 * time.Second
+	cfg.server.writeTimeout = 30 * time.Second
+	cfg.server.shutdownTimeout = 5 * time.Second
+
+	cfg.limiter.rps = 2
+	cfg.limiter.burst = 4
+	cfg.limiter.enabled = true
+
+	cfg.cors.trustedOrigins = []string{"http://localhost:9000"}
+	cfg.cors.allowedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	cfg.cors.allowedHeaders = []string{"Authorization", "Content-Type", "Idempotency-Key", "If-Match", "If-None-Match", "Read-Primary-Until", "X-Request-ID"}
+	cfg.cors.exposedHeaders = []string{"ETag", "Location", "X-Request-ID", "Idempotent-Replayed", "Read-Primary-Until"}
+	cfg.cors.maxAge = 10 * time.Minute
+
+	cfg.tokens.authenticationTTL = time.Hour
+	cfg.tokens.activationTTL = 3 * 24 * time.Hour
+	cfg.users.bcryptCost = 11
+
+	cfg.idempotency.ttl = 24 * time.Hour
+	cfg.cache.ttl = 30 * time.Second
+	cfg.httpCache.ttl = 10 * time.Second
+
+	cfg.log.format = logFormatJSON
+	cfg.log.level = "info"
+
+	cfg.trace.exporter = "none"
+	cfg.trace.file = "traces.jsonl"
+	cfg.trace.otlpEndpoint = "http://localhost:4318/v1/traces"
+
+	cfg.health.timeout = 2 * time.Second
+	cfg.errorFormat = errorFormatEnvelope
+	return cfg
+}
+
+// setting binds one configuration key to a config field. The same key is used in
+// config files ("db.max-open-conns"), as a flag ("-db-max-open-conns") and as an
+// environment variable ("MOVIE_API_DB_MAX_OPEN_CONNS").
+type setting struct {
+	key    string
+	flag   string // overrides the flag name derived from key
+	usage  string
+	secret bool
+	isBool bool
+	set    func(string) error
+	get    func() string
+}
+
+func (s setting) flagName() string {
+	if s.flag != "" {
+		return s.flag
+	}
+	return strings.ReplaceAll(s.key, ".", "-")
+}
+
+func (s setting) envName() string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(s.key))
+}
+
+func intSetting(key string, p *int, usage string) setting {
+	return setting{key: key, usage: usage,
+		set: func(s string) error {
+			v, err := strconv.Atoi(s)
+			if err == nil {
+				*p = v
+			}
+			return err
+		},
+		get: func() string { return strconv.Itoa(*p) },
+	}
+}
+
+func floatSetting(key string, p *float64, usage string) setting {
+	return setting{key: key, usage: usage,
+		set: func(s string) error {
+			v, err := strconv.ParseFloat(s, 64)
+			if err == nil {
+				*p = v
+			}
+			return err
+		},
+		get: func() string { return strconv.FormatFloat(*p, 'g', -1, 64) },
+	}
+}
+
+func boolSetting(key string, p *bool, usage string) setting {
+	return setting{key: key, usage: usage, isBool: true,
+		set: func(s string) error {
+			v, err := strconv.ParseBool(s)
+			if err == nil {
+				*p = v
+			}
+			return err
+		},
+		get: func() string { return strconv.FormatBool(*p) },
+	}
+}
+
+func stringSetting(key string, p *string, usage string) setting {
+	return setting{key: key, usage: usage,
+		set: func(s string) error { *p = s; return nil },
+		get: func() string { return *p },
+	}
+}
+
+func durationSetting(key string, p *time.Duration, usage string) setting {
+	return setting{key: key, usage: usage,
+		set: func(s string) error {
+			v, err := time.ParseDuration(s)
+			if err == nil {
+				*p = v
+			}
+			return err
+		},
+		get: func() string { return p.String() },
+	}
+}
+
+func listSetting(key string, p *[]string, usage string) setting {
+	return setting{key: key, usage: usage,
+		set: func(s string) error {
+			*p = nil
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*p = append(*p, item)
+				}
+			}
+			return nil
+		},
+		get: func() string { return strings.Join(*p, ",") },
+	}
+}
+
+func (cfg *config) settings() []setting {
+	dsn := stringSetting("db.dsn", &cfg.db.dsn, "PostgreSQL DSN")
+	dsn.secret = true
+	replicaDSNs := listSetting("db.replica-dsns", &cfg.db.replicaDSNs, "Comma separated list of PostgreSQL read-replica DSNs serving movie reads and user lookups by email")
+	replicaDSNs.secret = true
+	// Kept under its original flag name.
+	shutdownDelay := durationSetting("health.shutdown-delay", &cfg.health.shutdownDelay, "How long to report not-ready before shutting down, so load balancers can drain traffic")
+	shutdownDelay.flag = "shutdown-delay"
+
+	return []setting{
+		intSetting("port", &cfg.port, "API server port"),
+		intSetting("admin-port", &cfg.adminPort, "Admin server port serving /metrics (0 disables it)"),
+		stringSetting("env", &cfg.env, "Environment (development|staging|production)"),
+
+		stringSetting("db.driver", &cfg.db.driver, "Database driver (postgres|sqlite|memory); sqlite needs a binary built with -tags sqlite, memory keeps all data in the process and loses it on exit"),
+		dsn,
+		intSetting("db.max-open-conns", &cfg.db.maxOpenConns, "PostgreSQL max open connections"),
+		intSetting("db.max-idle-conns", &cfg.db.maxIdleConns, "PostgreSQL max idle connections"),
+		durationSetting("db.max-idle-time", &cfg.db.maxIdleTime, "PostgreSQL max connection idle time"),
+		durationSetting("db.read-timeout", &cfg.db.timeouts.Read, "Timeout for a single read query, also enforced by PostgreSQL as its statement_timeout"),
+		durationSetting("db.write-timeout", &cfg.db.timeouts.Write, "Timeout for a single write query, also enforced by PostgreSQL as its statement_timeout"),
+		durationSetting("db.lock-timeout", &cfg.db.lockTimeout, "PostgreSQL lock_timeout of every query (0 waits forever)"),
+		intSetting("db.tx-attempts", &cfg.db.txAttempts, "How many times a transaction aborted by a serialization failure or a deadlock is run"),
+		durationSetting("db.slow-query-threshold", &cfg.db.slowQueryThreshold, "Log queries that take longer than this (0 disables the log)"),
+		replicaDSNs,
+		durationSetting("db.replica-check-interval", &cfg.db.replicaCheckInterval, "How often replicas are pinged; reads fail over to the primary while none answers"),
+		durationSetting("db.read-your-writes-window", &cfg.db.readYourWritesWindow, "How long a client's reads go to the primary after it wrote"),
+
+		durationSetting("server.idle-timeout", &cfg.server.idleTimeout, "Keep-alive connection idle timeout"),
+		durationSetting("server.read-timeout", &cfg.server.readTimeout, "Timeout for reading a whole request"),
+		durationSetting("server.write-timeout", &cfg.server.writeTimeout, "Timeout for writing a response"),
+		durationSetting("server.shutdown-timeout", &cfg.server.shutdownTimeout, "Time allowed for in-flight requests to finish on shutdown"),
+
+		floatSetting("limiter.rps", &cfg.limiter.rps, "Rate limiter maximum requests per second"),
+		intSetting("limiter.burst", &cfg.limiter.burst, "Rate limiter maximum burst"),
+		boolSetting("limiter.enabled", &cfg.limiter.enabled, "Enable rate limiter"),
+
+		listSetting("cors.trusted-origins", &cfg.cors.trustedOrigins, "Comma separated list of trusted CORS origins; https://*.example.com trusts every subdomain and * any origin"),
+		listSetting("cors.allowed-methods", &cfg.cors.allowedMethods, "Methods allowed in cross-origin requests, further limited to those each route serves"),
+		listSetting("cors.allowed-headers", &cfg.cors.allowedHeaders, "Request headers allowed in cross-origin requests"),
+		listSetting("cors.exposed-headers", &cfg.cors.exposedHeaders, "Response headers readable by cross-origin scripts"),
+		boolSetting("cors.allow-credentials", &cfg.cors.allowCredentials, "Allow cross-origin requests with cookies or HTTP authentication"),
+		durationSetting("cors.max-age", &cfg.cors.maxAge, "How long browsers may cache preflight responses"),
+
+		durationSetting("tokens.authentication-ttl", &cfg.tokens.authenticationTTL, "Lifetime of authentication tokens"),
+		durationSetting("tokens.activation-ttl", &cfg.tokens.activationTTL, "Lifetime of the activation tokens created on registration"),
+		intSetting("users.bcrypt-cost", &cfg.users.bcryptCost, "bcrypt cost used to hash passwords"),
+
+		durationSetting("idempotency.ttl", &cfg.idempotency.ttl, "How long responses to requests with an Idempotency-Key are kept"),
+
+		intSetting("cache.size", &cfg.cache.size, "Number of movies, and of pages of movie listings, cached in memory (0 disables the cache); several instances sharing a database also need cache.notify"),
+		durationSetting("cache.ttl", &cfg.cache.ttl, "How long a cached movie or listing may be served"),
+		boolSetting("cache.notify", &cfg.cache.notify, "Share cache invalidations with the other instances through PostgreSQL LISTEN/NOTIFY"),
+
+		durationSetting("http-cache.max-age", &cfg.httpCache.maxAge, "max-age of the Cache-Control header of movie reads (0 makes clients revalidate every time and keeps them out of the response cache)"),
+		intSetting("http-cache.size", &cfg.httpCache.size, "Number of responses to anonymous GET requests cached in memory (0 disables the response cache)"),
+		durationSetting("http-cache.ttl", &cfg.httpCache.ttl, "How long a cached response may be served; writes through this instance drop the cache earlier"),
+
+		stringSetting("log.format", &cfg.log.format, "Log format (json|logfmt)"),
+		stringSetting("log.level", &cfg.log.level, "Minimum log level (debug|info|warn|error)"),
+
+		stringSetting("trace.exporter", &cfg.trace.exporter, "Trace exporter (none|stdout|file|otlp)"),
+		stringSetting("trace.file", &cfg.trace.file, "File written by the file trace exporter"),
+		stringSetting("trace.otlp-endpoint", &cfg.trace.otlpEndpoint, "OTLP/HTTP traces endpoint"),
+
+		durationSetting("health.timeout", &cfg.health.timeout, "Timeout for each readiness check"),
+		shutdownDelay,
+
+		stringSetting("error-format", &cfg.errorFormat, "Default error response format (envelope|problem)"),
+		boolSetting("auto-migrate", &cfg.autoMigrate, "Apply pending database migrations at startup"),
+	}
+}
+
+// configSource remembers where the configuration came from so that it can be loaded again.
+type configSource struct {
+	file  string
+	flags map[string]string
+}
+
+// flagValue records the raw value of a flag; it is applied to the config only after
+// the file and the environment, so that flags always win.
+type flagValue struct {
+	name   string
+	isBool bool
+	values map[string]string
+}
+
+func (f flagValue) String() string     { return "" }
+func (f flagValue) IsBoolFlag() bool   { return f.isBool }
+func (f flagValue) Set(s string) error { f.values[f.name] = s; return nil }
+
+// configFlags defines -config and a flag for every setting on fs. The values of the
+// flags are recorded in the returned source once fs is parsed.
+func configFlags(fs *flag.FlagSet) *configSource {
+	src := &configSource{flags: make(map[string]string)}
+	fs.StringVar(&src.file, "config", os.Getenv(envPrefix+"CONFIG"), "Path to a YAML or TOML config file")
+	defaults := defaultConfig()
+	for _, s := range defaults.settings() {
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.envName())
+		fs.Var(flagValue{name: s.key, isBool: s.isBool, values: src.flags}, s.flagName(), usage)
+	}
+	return src
+}
+
+// printFlags lists the flags of fs along with the defaults of the settings they set.
+func printFlags(fs *flag.FlagSet) {
+	defaults := defaultConfig()
+	fs.VisitAll(func(f *flag.Flag) {
+		def := ""
+		for _, s := range defaults.settings() {
+			if s.flagName() == f.Name && s.get() != "" && s.get() != "0s" {
+				def = fmt.Sprintf(" (default %s)", s.get())
+			}
+		}
+		fmt.Fprintf(fs.Output(), "  -%s\n    \t%s%s\n", f.Name, f.Usage, def)
+	})
+}
+
+// parseFlags parses the command line. It returns the source to load the
+// configuration from and whether -print-config was given.
+func parseFlags(args []string) (configSource, bool, error) {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	src := configFlags(fs)
+	printConfig := fs.Bool("print-config", false, "Print the effective configuration, with secrets redacted, and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: api [flags]\n       api migrate [flags] <command>\n\nConfiguration is read from defaults, then -config file, then %s* variables, then flags.\n\n", envPrefix)
+		printFlags(fs)
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return configSource{}, false, err
+	}
+	if fs.NArg() > 0 {
+		return configSource{}, false, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	return *src, *printConfig, nil
+}
+
+// load builds the configuration from defaults, the config file, the environment
+// and the flags, in increasing order of precedence, and validates the result.
+func (src configSource) load() (config, error) {
+	cfg := defaultConfig()
+	settings := cfg.settings()
+
+	var errs []error
+	apply := func(s setting, value, origin string) {
+		err := s.set(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q for %s", origin, value, s.key))
+		}
+	}
+
+	if src.file != "" {
+		f, err := os.Open(src.file)
+		if err != nil {
+			return config{}, err
+		}
+		values, err := configfile.Parse(src.file, f)
+		f.Close()
+		if err != nil {
+			return config{}, fmt.Errorf("%s: %w", src.file, err)
+		}
+		known := make(map[string]bool)
+		for _, s := range settings {
+			known[s.key] = true
+			if value, ok := values[s.key]; ok {
+				apply(s, value, src.file)
+			}
+		}
+		for key := range values {
+			if !known[key] {
+				errs = append(errs, fmt.Errorf("%s: unknown setting %q", src.file, key))
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.envName()); ok {
+			apply(s, value, s.envName())
+		}
+	}
+	for _, s := range settings {
+		if value, ok := src.flags[s.key]; ok {
+			apply(s, value, "-"+s.flagName())
+		}
+	}
+	if len(errs) > 0 {
+		return config{}, errors.Join(errs...)
+	}
+	return cfg, cfg.validate()
+}
+
+func (cfg config) validate() error {
+	v := validator.New()
+
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(cfg.adminPort >= 0 && cfg.adminPort <= 65535, "admin-port", "must be between 0 and 65535")
+	v.Check(cfg.adminPort != cfg.port, "admin-port", "must differ from port")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(validator.In(cfg.db.driver, "postgres", "sqlite", "memory"), "db.driver", "must be postgres, sqlite or memory")
+	v.Check(cfg.db.dsn != "" || cfg.db.driver == "memory", "db.dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns > 0, "db.max-open-conns", "must be greater than zero")
+	v.Check(cfg.db.maxIdleConns >= 0, "db.max-idle-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleTime > 0, "db.max-idle-time", "must be greater than zero")
+	v.Check(cfg.db.timeouts.Read > 0, "db.read-timeout", "must be greater than zero")
+	v.Check(cfg.db.timeouts.Write > 0, "db.write-timeout", "must be greater than zero")
+	v.Check(cfg.db.lockTimeout >= 0, "db.lock-timeout", "must not be negative")
+	v.Check(cfg.db.txAttempts > 0, "db.tx-attempts", "must be greater than zero")
+	v.Check(cfg.db.slowQueryThreshold >= 0, "db.slow-query-threshold", "must not be negative")
+	v.Check(len(cfg.db.replicaDSNs) == 0 || cfg.db.driver == "postgres", "db.replica-dsns", "are only supported by the postgres driver")
+	v.Check(cfg.db.replicaCheckInterval > 0, "db.replica-check-interval", "must be greater than zero")
+	v.Check(cfg.db.readYourWritesWindow >= 0, "db.read-your-writes-window", "must not be negative")
+
+	v.Check(cfg.server.idleTimeout > 0, "server.idle-timeout", "must be greater than zero")
+	v.Check(cfg.server.readTimeout > 0, "server.read-timeout", "must be greater than zero")
+	v.Check(cfg.server.writeTimeout > 0, "server.write-timeout", "must be greater than zero")
+	v.Check(cfg.server.shutdownTimeout > 0, "server.shutdown-timeout", "must be greater than zero")
+
+	v.Check(cfg.limiter.rps > 0, "limiter.rps", "must be greater than zero")
+	v.Check(cfg.limiter.burst > 0, "limiter.burst", "must be greater than zero")
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		_, _, err := parseOrigin(origin)
+		if err != nil {
+			v.AddError("cors.trusted-origins", err.Error())
+		}
+		v.Check(origin != "*" || !cfg.cors.allowCredentials, "cors.allow-credentials", "cannot be used when any origin (*) is trusted")
+	}
+	for _, method := range cfg.cors.allowedMethods {
+		v.Check(validator.In(method, "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"), "cors.allowed-methods",
+			fmt.Sprintf("%q is not one of GET, HEAD, POST, PUT, PATCH or DELETE", method))
+	}
+	v.Check(cfg.cors.maxAge >= 0, "cors.max-age", "must not be negative")
+
+	v.Check(cfg.tokens.authenticationTTL > 0, "tokens.authentication-ttl", "must be greater than zero")
+	v.Check(cfg.tokens.activationTTL > 0, "tokens.activation-ttl", "must be greater than zero")
+	v.Check(cfg.users.bcryptCost >= 4 && cfg.users.bcryptCost <= 31, "users.bcrypt-cost", "must be between 4 and 31")
+
+	v.Check(cfg.idempotency.ttl > 0, "idempotency.ttl", "must be greater than zero")
+	v.Check(cfg.cache.size >= 0, "cache.size", "must not be negative")
+	v.Check(cfg.cache.ttl > 0, "cache.ttl", "must be greater than zero")
+	v.Check(!cfg.cache.notify || cfg.db.driver == "postgres", "cache.notify", "is only supported by the postgres driver")
+	v.Check(cfg.httpCache.maxAge >= 0, "http-cache.max-age", "must not be negative")
+	v.Check(cfg.httpCache.size >= 0, "http-cache.size", "must not be negative")
+	v.Check(cfg.httpCache.ttl > 0, "http-cache.ttl", "must be greater than zero")
+
+	v.Check(validator.In(cfg.log.format, logFormatJSON, logFormatLogfmt), "log.format", "must be json or logfmt")
+	var level slog.Level
+	v.Check(level.UnmarshalText([]byte(cfg.log.level)) == nil, "log.level", "must be debug, info, warn or error")
+
+	v.Check(validator.In(cfg.trace.exporter, "none", "stdout", "file", "otlp"), "trace.exporter", "must be none, stdout, file or otlp")
+	v.Check(cfg.trace.exporter != "file" || cfg.trace.file != "", "trace.file", "must be provided for the file exporter")
+	v.Check(cfg.trace.exporter != "otlp" || cfg.trace.otlpEndpoint != "", "trace.otlp-endpoint", "must be provided for the otlp exporter")
+
+	v.Check(cfg.health.timeout > 0, "health.timeout", "must be greater than zero")
+	v.Check(cfg.health.shutdownDelay >= 0, "health.shutdown-delay", "must not be negative")
+
+	v.Check(validator.In(cfg.errorFormat, errorFormatEnvelope, errorFormatProblem), "error-format", "must be envelope or problem")
+
+	if v.Valid() {
+		return nil
+	}
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		errs[i] = fmt.Errorf("%s %s", key, v.Errors[key])
+	}
+	return errors.Join(errs...)
+}
+
+// print writes the effective configuration in TOML-like "key = value" form.
+func (cfg config) print(w io.Writer) {
+	for _, s := range cfg.settings() {
+		value := s.get()
+		if s.secret {
+			items := strings.Split(value, ",")
+			for i, item := range items {
+				items[i] = redact(item)
+			}
+			value = strings.Join(items, ",")
+		}
+		fmt.Fprintf(w, "%s = %q\n", s.key, value)
+	}
+}
+
+// keywordPassword matches the password of a keyword/value DSN such as
+// "host=localhost password='secret' dbname=movies", quoted or not.
+var keywordPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s']*)`)
+
+// redact hides the passwords of a URL or keyword/value DSN, or the whole value when
+// it is neither.
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	u, err := url.Parse(value)
+	if err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		if query := u.Query(); query.Has("password") {
+			query.Set("password", "REDACTED")
+			u.RawQuery = query.Encode()
+		}
+		return u.String()
+	}
+	if strings.Contains(value, "=") {
+		return keywordPassword.ReplaceAllString(value, "${1}REDACTED")
+	}
+	return "[REDACTED]"
+}
@@ -8,9 +8,24 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey         = contextKey("user")
+	requestIDContextKey    = contextKey("request_id")
+	requestStateContextKey = contextKey("request_state")
+)
+
+// requestState is shared by every middleware and handler of one request. Unlike
+// context values it is mutable, so outer middleware such as the logger can see
+// what inner handlers learned about the request.
+type requestState struct {
+	user  *data.User
+	route string
+}
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if state := contextGetRequestState(r); state != nil {
+		state.user = user
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -22,3 +37,23 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) contextSetRequestState(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), requestStateContextKey, &requestState{})
+	return r.WithContext(ctx)
+}
+
+func contextGetRequestState(r *http.Request) *requestState {
+	state, _ := r.Context().Value(requestStateContextKey).(*requestState)
+	return state
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+func contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
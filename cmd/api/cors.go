@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy describes which cross-origin requests browsers may make.
+type corsPolicy struct {
+	origins          map[string]bool
+	wildcards        []wildcardOrigin
+	anyOrigin        bool
+	methods          []string
+	allowedHeaders   []string
+	exposedHeaders   []string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+// wildcardOrigin matches any subdomain of a host, such as https://*.example.com.
+type wildcardOrigin struct {
+	prefix string // "https://"
+	suffix string // ".example.com", including the port if any
+}
+
+func (o wildcardOrigin) match(origin string) bool {
+	if !strings.HasPrefix(origin, o.prefix) || !strings.HasSuffix(origin, o.suffix) {
+		return false
+	}
+	sub := origin[len(o.prefix) : len(origin)-len(o.suffix)]
+	return sub != "" && !strings.ContainsAny(sub, "/:@") && !strings.HasPrefix(sub, ".") && !strings.HasSuffix(sub, ".")
+}
+
+// parseOrigin checks an entry of cors.trusted-origins: "*", scheme://host[:port] or
+// scheme://*.host[:port].
+func parseOrigin(pattern string) (wildcardOrigin, bool, error) {
+	if pattern == "*" {
+		return wildcardOrigin{}, false, nil
+	}
+	scheme, host, ok := strings.Cut(pattern, "://")
+	if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#@") {
+		return wildcardOrigin{}, false, fmt.Errorf("%q must be a scheme and host such as https://example.com", pattern)
+	}
+	if name, ok := strings.CutPrefix(host, "*."); ok {
+		if name == "" || strings.Contains(name, "*") {
+			return wildcardOrigin{}, false, fmt.Errorf("%q is not a valid wildcard origin", pattern)
+		}
+		return wildcardOrigin{prefix: scheme + "://", suffix: "." + name}, true, nil
+	}
+	if strings.Contains(host, "*") {
+		return wildcardOrigin{}, false, fmt.Errorf("%q may only use a wildcard as the first label, as in https://*.example.com", pattern)
+	}
+	return wildcardOrigin{}, false, nil
+}
+
+func newCORSPolicy(cfg config) *corsPolicy {
+	p := &corsPolicy{
+		origins:          make(map[string]bool),
+		methods:          cfg.cors.allowedMethods,
+		allowedHeaders:   cfg.cors.allowedHeaders,
+		exposedHeaders:   cfg.cors.exposedHeaders,
+		allowCredentials: cfg.cors.allowCredentials,
+		maxAge:           cfg.cors.maxAge,
+	}
+	for _, pattern := range cfg.cors.trustedOrigins {
+		// Patterns were checked by validate.
+		wildcard, isWildcard, _ := parseOrigin(pattern)
+		switch {
+		case pattern == "*":
+			p.anyOrigin = true
+		case isWildcard:
+			p.wildcards = append(p.wildcards, wildcard)
+		default:
+			p.origins[strings.ToLower(pattern)] = true
+		}
+	}
+	return p
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if w.match(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedMethods returns the configured methods among those served by a route.
+func (p *corsPolicy) allowedMethods(served []string) []string {
+	var methods []string
+	for _, m := range p.methods {
+		for _, s := range served {
+			if strings.EqualFold(m, s) {
+				methods = append(methods, s)
+				break
+			}
+		}
+	}
+	return methods
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS applies the CORS policy held by the application, which can be replaced on SIGHUP.
+// Preflight requests are answered here with 204 No Content and never reach the handlers;
+// routeMethods lists the methods served for a path, so that each route advertises its own.
+func (app *application) CORS(routeMethods func(path string) []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := app.cors.Load()
+			w.Header().Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+			if origin == "" || !policy.allowOrigin(origin) {
+				if preflight && origin != "" {
+					// Without any Access-Control-Allow-* header the browser rejects the request.
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if policy.anyOrigin && !policy.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if policy.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if len(policy.exposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.exposedHeaders, ", "))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			methods := policy.allowedMethods(routeMethods(r.URL.Path))
+			if len(methods) == 0 {
+				// No route for this path: let the router answer with 404.
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+			for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				if h = strings.TrimSpace(h); h != "" && !containsFold(policy.allowedHeaders, h) {
+					// Leave out Allow-Headers so that the browser reports the disallowed header.
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			if len(policy.allowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.allowedHeaders, ", "))
+			}
+			if policy.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// routeMethods returns a function listing the methods for which router has a route
+// matching a path below prefix.
+func routeMethods(prefix string, router *http.ServeMux) func(path string) []string {
+	return func(path string) []string {
+		path, ok := strings.CutPrefix(path, prefix)
+		if !ok {
+			return nil
+		}
+		var methods []string
+		for _, m := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			r, err := http.NewRequest(m, path, nil)
+			if err != nil {
+				return nil
+			}
+			if _, pattern := router.Handler(r); pattern != "" {
+				methods = append(methods, m)
+			}
+		}
+		return methods
+	}
+}
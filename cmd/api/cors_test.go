@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	trust := func(origins ...string) func(cfg *config) {
+		return func(cfg *config) { cfg.cors.trustedOrigins = origins }
+	}
+	preflight := func(path, origin, method string) testRequest {
+		return testRequest{method: "OPTIONS", path: path,
+			header: map[string]string{"Origin": origin, "Access-Control-Request-Method": method}}
+	}
+	get := func(origin string) testRequest {
+		return testRequest{method: "GET", path: "/v1/movies/1", header: map[string]string{"Origin": origin}}
+	}
+
+	tests := []struct {
+		name      string
+		configure func(cfg *config)
+		req       testRequest
+		status    int
+		// want holds the expected response headers, "" meaning that the header is absent.
+		want map[string]string
+	}{
+		{name: "NoOrigin", req: testRequest{method: "GET", path: "/v1/movies/1"}, status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "ExactOrigin", req: get("http://localhost:9000"), status: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "http://localhost:9000",
+				"Access-Control-Allow-Credentials": "",
+				"Access-Control-Expose-Headers":    "ETag, Location, X-Request-ID, Idempotent-Replayed, Read-Primary-Until",
+			}},
+		{name: "ExactOriginCase", req: get("HTTP://LOCALHOST:9000"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": "HTTP://LOCALHOST:9000"}},
+		{name: "UntrustedOrigin", req: get("http://localhost:9001"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Expose-Headers": ""}},
+
+		{name: "WildcardSubdomain", configure: trust("https://*.example.com"), req: get("https://api.example.com"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": "https://api.example.com"}},
+		{name: "WildcardNestedSubdomain", configure: trust("https://*.example.com"), req: get("https://eu.api.example.com"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": "https://eu.api.example.com"}},
+		{name: "WildcardBareDomain", configure: trust("https://*.example.com"), req: get("https://example.com"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "WildcardAttackerSuffix", configure: trust("https://*.example.com"), req: get("https://evil.com.example.com.attacker"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "WildcardLookalike", configure: trust("https://*.example.com"), req: get("https://evilexample.com"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "WildcardOtherScheme", configure: trust("https://*.example.com"), req: get("http://api.example.com"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "WildcardUserinfo", configure: trust("https://*.example.com"), req: get("https://evil.com@api.example.com"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "WildcardPort", configure: trust("https://*.example.com"), req: get("https://api.example.com:8443"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": ""}},
+
+		{name: "AnyOrigin", configure: trust("*"), req: get("https://anywhere.test"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Credentials": ""}},
+		{name: "CredentialsWithWildcard", configure: func(cfg *config) {
+			cfg.cors.trustedOrigins = []string{"https://*.example.com"}
+			cfg.cors.allowCredentials = true
+		}, req: get("https://api.example.com"), status: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://api.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			}},
+		{name: "CredentialsUntrustedOrigin", configure: func(cfg *config) {
+			cfg.cors.trustedOrigins = []string{"https://*.example.com"}
+			cfg.cors.allowCredentials = true
+		}, req: get("https://api.example.org"), status: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Credentials": ""}},
+
+		{name: "PreflightItem", req: preflight("/v1/movies/1", "http://localhost:9000", "PATCH"), status: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "http://localhost:9000",
+				"Access-Control-Allow-Methods": "GET, HEAD, PATCH, DELETE",
+				"Access-Control-Allow-Headers": "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match, Read-Primary-Until, X-Request-ID",
+				"Access-Control-Max-Age":       "600",
+			}},
+		{name: "PreflightCollection", req: preflight("/v1/movies", "http://localhost:9000", "POST"), status: http.StatusNoContent,
+			want: map[string]string{"Access-Control-Allow-Methods": "GET, HEAD, POST"}},
+		{name: "PreflightConfiguredMethods", configure: func(cfg *config) { cfg.cors.allowedMethods = []string{"GET", "DELETE"} },
+			req: preflight("/v1/movies/1", "http://localhost:9000", "DELETE"), status: http.StatusNoContent,
+			want: map[string]string{"Access-Control-Allow-Methods": "GET, DELETE"}},
+		{name: "PreflightDisallowedHeader", req: testRequest{method: "OPTIONS", path: "/v1/movies/1", header: map[string]string{
+			"Origin": "http://localhost:9000", "Access-Control-Request-Method": "PATCH", "Access-Control-Request-Headers": "content-type, x-secret",
+		}}, status: http.StatusNoContent,
+			want: map[string]string{"Access-Control-Allow-Methods": "GET, HEAD, PATCH, DELETE", "Access-Control-Allow-Headers": ""}},
+		{name: "PreflightUntrustedOrigin", req: preflight("/v1/movies/1", "https://evil.test", "DELETE"), status: http.StatusNoContent,
+			want: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""}},
+		{name: "PreflightUnknownRoute", req: preflight("/v1/unknown", "http://localhost:9000", "GET"), status: http.StatusNotFound,
+			want: map[string]string{"Access-Control-Allow-Methods": ""}},
+		{name: "PreflightNoMaxAge", configure: func(cfg *config) { cfg.cors.maxAge = 0 },
+			req: preflight("/v1/movies/1", "http://localhost:9000", "GET"), status: http.StatusNoContent,
+			want: map[string]string{"Access-Control-Max-Age": ""}},
+		{name: "PreflightCredentials", configure: func(cfg *config) { cfg.cors.allowCredentials = true },
+			req: preflight("/v1/movies/1", "http://localhost:9000", "GET"), status: http.StatusNoContent,
+			want: map[string]string{"Access-Control-Allow-Credentials": "true"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.seedMovie(t, "Alien", 1979, 117, "sci-fi")
+			if tt.configure != nil {
+				cfg := app.config
+				tt.configure(&cfg)
+				if err := cfg.validate(); err != nil {
+					t.Fatal(err)
+				}
+				app.applyConfig(cfg)
+			}
+
+			w := app.do(t, tt.req)
+			if w.Code != tt.status {
+				t.Errorf("status %d; want %d", w.Code, tt.status)
+			}
+			for key, want := range tt.want {
+				if got := w.Header().Get(key); got != want {
+					t.Errorf("%s = %q; want %q", key, got, want)
+				}
+			}
+			// Responses differ by origin whether or not it is trusted, so caches must key on it.
+			if vary := w.Header().Values("Vary"); !slices.Contains(vary, "Origin") {
+				t.Errorf("Vary = %q; want it to include Origin", vary)
+			}
+		})
+	}
+}
+
+func TestCORSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		creds   bool
+		valid   bool
+	}{
+		{"Exact", []string{"https://example.com", "http://localhost:9000"}, true, true},
+		{"Wildcard", []string{"https://*.example.com"}, true, true},
+		{"AnyOrigin", []string{"*"}, false, true},
+		{"AnyOriginWithCredentials", []string{"*"}, true, false},
+		{"NoScheme", []string{"example.com"}, false, false},
+		{"Path", []string{"https://example.com/app"}, false, false},
+		{"InnerWildcard", []string{"https://api.*.example.com"}, false, false},
+		{"BareWildcard", []string{"https://*."}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.db.driver = "memory"
+			cfg.cors.trustedOrigins = tt.origins
+			cfg.cors.allowCredentials = tt.creds
+			if err := cfg.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate() = %v; want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
@@ -1,60 +1,200 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"simplewebapi.moviedb/internal/data"
+	"sort"
+	"strings"
 )
 
-func (app *application) logError(r *http.Request, message string) {
-	app.logger.Error(message)
+const (
+	errorFormatEnvelope = "envelope"
+	errorFormatProblem  = "problem"
+
+	problemTypeBase = "urn:moviedb:problem:"
+)
+
+func (app *application) logError(r *http.Request, err error) {
+	attrs := append(requestAttrs(r), slog.String("error", err.Error()))
+	app.logger.LogAttrs(r.Context(), slog.LevelError, "request failed", attrs...)
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
-	e := envelope{"error": message}
+// wantsProblem reports whether the error should be written as RFC 9457 problem details,
+// either because the client asked for it or because it is the configured default.
+func (app *application) wantsProblem(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/problem+json"):
+		return true
+	case app.config.errorFormat == errorFormatProblem:
+		return true
+	}
+	return false
+}
 
-	err := app.writeJSON(w, status, e, nil)
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message interface{}) {
+	var (
+		e       envelope
+		headers http.Header
+	)
+	if app.wantsProblem(r) {
+		e = problem(r, status, code, message)
+		headers = http.Header{"Content-Type": {"application/problem+json"}}
+	} else {
+		e = envelope{"error": message}
+	}
+	// Server errors carry the request ID so that users can quote it when reporting the problem.
+	if id := contextGetRequestID(r); id != "" && status >= http.StatusInternalServerError {
+		e["request_id"] = id
+	}
+
+	err := app.writeJSON(w, status, e, headers)
 	if err != nil {
-		app.logError(r, err.Error())
+		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 
 }
 
+// problem builds an RFC 9457 problem details object. Validation messages keyed by
+// field are reported in an "errors" array, each entry pointing at the offending field.
+// The instance is the URI as the client sent it, before the /v1 prefix was stripped.
+func problem(r *http.Request, status int, code string, message interface{}) envelope {
+	instance := r.RequestURI
+	if instance == "" {
+		instance = r.URL.RequestURI()
+	}
+	p := envelope{
+		"type":     problemTypeBase + code,
+		"title":    http.StatusText(status),
+		"status":   status,
+		"instance": instance,
+		"code":     code,
+	}
+	switch m := message.(type) {
+	case string:
+		p["detail"] = m
+	case map[string]string:
+		p["detail"] = "one or more fields failed validation"
+		fields := make([]string, 0, len(m))
+		for field := range m {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		errs := make([]map[string]string, 0, len(fields))
+		for _, field := range fields {
+			errs = append(errs, map[string]string{
+				"pointer": "/" + strings.ReplaceAll(strings.ReplaceAll(field, "~", "~0"), "/", "~1"),
+				"detail":  m[field],
+			})
+		}
+		p["errors"] = errs
+	}
+	return p
+}
+
+// statusClientClosedRequest is the non-standard status, borrowed from nginx, recorded
+// when the client disconnected before its request could be answered.
+const statusClientClosedRequest = 499
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, data.ErrCanceled) {
+		attrs := append(requestAttrs(r), slog.String("error", err.Error()))
+		app.logger.LogAttrs(r.Context(), slog.LevelWarn, "request canceled by client", attrs...)
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	app.logError(r, err)
 
+	if errors.Is(err, data.ErrTimeout) {
+		message := "the database did not answer in time, please try again later"
+		app.errorResponse(w, r, http.StatusGatewayTimeout, "timeout", message)
+		return
+	}
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, "server_error", message)
+}
+
+// dbErrorStatus picks the response for an error of the repositories that the client
+// can act on: a write rejected by a constraint or by a concurrent transaction. ok is
+// false for any other error.
+func dbErrorStatus(err error) (status int, code string, message interface{}, ok bool) {
+	var constraintErr *data.ConstraintError
+	switch {
+	case errors.As(err, &constraintErr):
+		status, code = http.StatusUnprocessableEntity, "validation_failed"
+		if constraintErr.Kind == data.UniqueConstraint {
+			status, code = http.StatusConflict, "conflict"
+		}
+		if constraintErr.Field == "" {
+			return status, code, constraintErr.Message, true
+		}
+		return status, code, map[string]string{constraintErr.Field: constraintErr.Message}, true
+	case errors.Is(err, data.ErrSerializationFailure), errors.Is(err, data.ErrDeadlock), errors.Is(err, data.ErrLockTimeout):
+		message := "unable to complete the request because of a concurrent update, please try again"
+		return http.StatusConflict, "concurrent_update", message, true
+	}
+	return 0, "", nil, false
+}
+
+// dbErrorResponse answers with the response chosen by dbErrorStatus, or a server error.
+func (app *application) dbErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	status, code, message, ok := dbErrorStatus(err)
+	if !ok {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.errorResponse(w, r, status, code, message)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "resource not found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, "not_found", message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, "method_not_allowed", message)
 }
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, "bad_request", err.Error())
 }
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "validation_failed", errors)
 }
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, "edit_conflict", message)
+}
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since it was last fetched, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, "precondition_failed", message)
+}
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", message)
+}
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Idempotency-Key has already been used with a different request payload"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "idempotency_key_mismatch", message)
+}
+func (app *application) idempotencyKeyInFlightResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with the same Idempotency-Key is still being processed, please try again later"
+	app.errorResponse(w, r, http.StatusConflict, "idempotency_key_in_flight", message)
 }
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, "rate_limit_exceeded", message)
 }
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_credentials", message)
 }
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_authentication_token", message)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"simplewebapi.moviedb/internal/data"
+	"strings"
+	"testing"
+	"time"
+)
+
+// erroringMovies is a MoviesRepoInterface whose reads and inserts fail with err.
+type erroringMovies struct {
+	data.MoviesRepoInterface
+	err error
+}
+
+func (m erroringMovies) Get(context.Context, int64) (*data.Movie, error) { return nil, m.err }
+func (m erroringMovies) Insert(context.Context, *data.Movie) error       { return m.err }
+
+func TestDBErrorResponses(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	create := func() *http.Request {
+		return httptest.NewRequest("POST", "/v1/movies", strings.NewReader(
+			`{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`))
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context // the context of the request, if not the default
+		err    error           // the error of the movies repository, if it is stubbed
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{name: "ClientCanceled", ctx: canceled, req: httptest.NewRequest("GET", "/v1/movies/1", nil),
+			status: statusClientClosedRequest},
+		{name: "Deadline", ctx: expired, req: httptest.NewRequest("GET", "/v1/movies/1", nil),
+			status: http.StatusGatewayTimeout, code: "timeout"},
+		{name: "Timeout", err: fmt.Errorf("%w: canceling statement due to statement timeout", data.ErrTimeout),
+			req: httptest.NewRequest("GET", "/v1/movies/1", nil), status: http.StatusGatewayTimeout, code: "timeout"},
+		{name: "TimeoutOnWrite", err: data.ErrTimeout, req: create(), status: http.StatusGatewayTimeout, code: "timeout"},
+		{name: "UniqueConstraint", err: &data.ConstraintError{Kind: data.UniqueConstraint, Constraint: "movies_title_key",
+			Field: "title", Message: "a movie with this title already exists"}, req: create(),
+			status: http.StatusConflict, code: "conflict"},
+		{name: "CheckConstraint", err: &data.ConstraintError{Kind: data.CheckConstraint, Constraint: "movies_year_check",
+			Field: "year", Message: "must be between 1888 and the current year"}, req: create(),
+			status: http.StatusUnprocessableEntity, code: "validation_failed"},
+		{name: "SerializationFailure", err: data.ErrSerializationFailure, req: create(),
+			status: http.StatusConflict, code: "concurrent_update"},
+		{name: "OtherError", err: errDatabaseDown, req: create(),
+			status: http.StatusInternalServerError, code: "server_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.errorFormat = errorFormatProblem
+			app.seedMovie(t, "Alien", 1979, 117, "sci-fi")
+			if tt.err != nil {
+				app.repos.Movies = erroringMovies{app.repos.Movies, tt.err}
+			}
+			r := tt.req
+			if tt.ctx != nil {
+				r = r.WithContext(tt.ctx)
+			}
+
+			w := httptest.NewRecorder()
+			app.handler().ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d; want %d", w.Code, tt.status)
+			}
+			if tt.code == "" {
+				if w.Body.Len() != 0 {
+					t.Errorf("a request whose client went away was answered with %q", w.Body)
+				}
+				return
+			}
+			var body struct{ Code string }
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.code {
+				t.Errorf("code %q; want %q", body.Code, tt.code)
+			}
+		})
+	}
+}
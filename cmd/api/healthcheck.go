@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -18,3 +21,88 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// livenessHandler only tells that the process is up and serving HTTP; it never
+// checks dependencies, so a database outage does not get the process restarted.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type componentHealth struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Version *int64 `json:"version,omitempty"`
+}
+
+// readinessHandler reports whether the instance should receive traffic. It answers
+// 503 with a per-component breakdown while a dependency is down or the server is draining.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	components := map[string]componentHealth{
+		"database": app.checkDatabase(r.Context()),
+	}
+	// The in-memory store has no schema to migrate.
+	if app.config.db.driver != "memory" {
+		components["migrations"] = app.checkMigrations(r.Context())
+	}
+
+	status := "ready"
+	code := http.StatusOK
+	for _, c := range components {
+		if c.Status != "up" {
+			status = "degraded"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	if app.shuttingDown.Load() {
+		status = "shutting_down"
+		code = http.StatusServiceUnavailable
+	}
+
+	err := app.writeJSON(w, code, envelope{"status": status, "components": components}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) checkDatabase(ctx context.Context) componentHealth {
+	ctx, cancel := context.WithTimeout(ctx, app.config.health.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := app.repos.Ping(ctx)
+	health := componentHealth{Status: "up", Latency: time.Since(start).String()}
+	if err != nil {
+		health.Status = "down"
+		health.Error = err.Error()
+	}
+	return health
+}
+
+func (app *application) checkMigrations(ctx context.Context) componentHealth {
+	ctx, cancel := context.WithTimeout(ctx, app.config.health.timeout)
+	defer cancel()
+
+	start := time.Now()
+	version, dirty, err := app.repos.MigrationVersion(ctx)
+	health := componentHealth{Status: "up", Latency: time.Since(start).String()}
+	switch {
+	case err != nil:
+		health.Status = "down"
+		health.Error = err.Error()
+	case dirty:
+		health.Status = "down"
+		health.Version = &version
+		health.Error = fmt.Sprintf("migration %d failed and left the schema dirty", version)
+	case version != app.schemaVersion:
+		health.Status = "down"
+		health.Version = &version
+		health.Error = fmt.Sprintf("schema is at version %d, expected %d", version, app.schemaVersion)
+	default:
+		health.Version = &version
+	}
+	return health
+}
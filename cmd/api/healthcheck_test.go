@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthcheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		req          testRequest
+		shuttingDown bool
+	}{
+		{name: "Healthcheck", req: testRequest{method: "GET", path: "/v1/healthcheck"}},
+		{name: "Live", req: testRequest{method: "GET", path: "/v1/health/live"}},
+		{name: "Ready", req: testRequest{method: "GET", path: "/v1/health/ready"}},
+		{name: "ReadyShuttingDown", req: testRequest{method: "GET", path: "/v1/health/ready"}, shuttingDown: true},
+		{name: "NotFound", req: testRequest{method: "GET", path: "/v1/health/unknown"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.shuttingDown.Store(tt.shuttingDown)
+			checkGolden(t, app.do(t, tt.req))
+		})
+	}
+}
+
+func TestProbesBypassRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 1
+	app.applyConfig(app.config)
+	handler := app.handler()
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+	if code := get("/v1/healthcheck"); code != http.StatusOK {
+		t.Fatalf("first request = %d; want 200", code)
+	}
+	if code := get("/v1/healthcheck"); code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit = %d; want 429", code)
+	}
+	for _, path := range []string{"/v1/health/live", "/v1/health/ready"} {
+		if code := get(path); code != http.StatusOK {
+			t.Errorf("%s over the limit = %d; want 200", path, code)
+		}
+	}
+}
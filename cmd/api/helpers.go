@@ -7,9 +7,12 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"simplewebapi.moviedb/internal/data"
 	"simplewebapi.moviedb/internal/validator"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
@@ -35,7 +38,9 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 		w.Header()[key] = value
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 	w.Write(jsonData)
 	return nil
@@ -76,6 +81,24 @@ func readListString(qs url.Values, key string, defaultVal []string) []string {
 	copy(res, ls)
 	return res
 }
+func (app *application) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			return nil, fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		default:
+			return nil, err
+		}
+	}
+	if len(body) == 0 {
+		return nil, errors.New("body must not be empty")
+	}
+	return body, nil
+}
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, input interface{}) error {
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
@@ -123,15 +146,83 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, input i
 	return nil
 }
 
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d"`, movie.ID, movie.Version)
+}
+
+// etagMatch reports whether etag appears in the comma separated list of entity tags
+// held by header. RFC 9110 has If-Match use the strong comparison, under which weak
+// tags never match, and If-None-Match the weak one, which ignores the W/ prefix.
+func etagMatch(header string, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || (candidate == etag && !strings.HasPrefix(etag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheControl returns the Cache-Control header of a response that may be reused for
+// maxAge, by shared caches when public is true and only by the client otherwise.
+func cacheControl(public bool, maxAge time.Duration) string {
+	scope := "private"
+	if public {
+		scope = "public"
+	}
+	return fmt.Sprintf("%s, max-age=%d", scope, int(maxAge/time.Second))
+}
+
+// notModified reports whether the conditional headers of r show that the client holds
+// the current representation, identified by etag and lastModified. As RFC 9110 requires,
+// If-Modified-Since is ignored when If-None-Match is present.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return etagMatch(match, etag, true)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
 func (app *application) BackgroundTask(fn func()) {
 	app.wg.Add(1)
+	app.metrics.backgroundStarted.Inc()
+	app.metrics.backgroundTasks.Add(1)
 	go func() {
 		defer app.wg.Done()
+		defer app.metrics.backgroundTasks.Add(-1)
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.Error(fmt.Sprintf("%s", err))
+				app.logger.Error("background task panicked", "error", fmt.Sprintf("%s", err))
 			}
 		}()
 		fn()
 	}()
 }
+
+// headerChanges splits the headers a handler left in after into those it set and the
+// values it added to headers that were already present in before.
+func headerChanges(before, after http.Header) (set, added http.Header) {
+	set, added = make(http.Header), make(http.Header)
+	for key, values := range after {
+		old, ok := before[key]
+		switch {
+		case !ok:
+			set[key] = slices.Clone(values)
+		case len(values) > len(old) && slices.Equal(values[:len(old)], old):
+			added[key] = slices.Clone(values[len(old):])
+		case !slices.Equal(values, old):
+			set[key] = slices.Clone(values)
+		}
+	}
+	return set, added
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"simplewebapi.moviedb/internal/data"
+	"slices"
+	"time"
+)
+
+// recordingWriter passes a response through to the client while keeping a copy
+// of the status and body so it can be replayed for a retried request.
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.statusCode == 0 {
+		w.statusCode = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must not be more than 255 characters long"))
+			return
+		}
+
+		body, err := app.readBody(w, r)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		record := &data.IdempotencyRecord{
+			Client:      idempotencyClient(r),
+			Key:         key,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			Fingerprint: data.Fingerprint(r.Method, r.URL.Path, body),
+			Expiry:      time.Now().Add(app.config.idempotency.ttl),
+		}
+		existing, err := app.repos.Idempotency.Reserve(r.Context(), record)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if existing != nil {
+			switch {
+			case !bytes.Equal(existing.Fingerprint, record.Fingerprint):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case existing.InFlight():
+				app.idempotencyKeyInFlightResponse(w, r)
+			default:
+				replayHeaders(w.Header(), existing.Headers)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		// Only the headers written by next are stored: those of the middlewares above,
+		// such as X-Request-ID and the CORS headers, belong to each request.
+		before := w.Header().Clone()
+		recorder := &recordingWriter{ResponseWriter: w}
+		defer func() {
+			if err := recover(); err != nil {
+				app.releaseIdempotencyKey(r, record)
+				panic(err)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+
+		// Server errors are not stored so that the client can retry them.
+		if recorder.statusCode == 0 || recorder.statusCode >= http.StatusInternalServerError {
+			app.releaseIdempotencyKey(r, record)
+			return
+		}
+		record.Status = recorder.statusCode
+		set, added := headerChanges(before, w.Header())
+		for key, values := range added {
+			set[key] = append(set[key], values...)
+		}
+		record.Headers = set
+		record.Body = recorder.body.Bytes()
+		err = app.repos.Idempotency.Complete(context.WithoutCancel(r.Context()), record)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
+
+// idempotencyClient identifies the sender of r, so that the keys chosen by one client
+// never replay the responses sent to another: authenticated requests by a digest of
+// their credentials, others by their remote address.
+func idempotencyClient(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		sum := sha256.Sum256([]byte(authorization))
+		return "auth:" + hex.EncodeToString(sum[:])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+// replayHeaders adds the stored headers of a response to header, keeping the values
+// the middlewares already set for the retried request.
+func replayHeaders(header, stored http.Header) {
+	for key, values := range stored {
+		for _, value := range values {
+			if !slices.Contains(header[key], value) {
+				header[key] = append(header[key], value)
+			}
+		}
+	}
+}
+
+func (app *application) releaseIdempotencyKey(r *http.Request, record *data.IdempotencyRecord) {
+	err := app.repos.Idempotency.Release(context.WithoutCancel(r.Context()), record)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// purgeIdempotencyKeys removes expired idempotency keys every interval until done is closed.
+func (app *application) purgeIdempotencyKeys(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := app.repos.Idempotency.DeleteExpired(context.Background())
+			if err != nil {
+				app.logger.Error("failed to purge idempotency keys", "error", err)
+			}
+		}
+	}
+}
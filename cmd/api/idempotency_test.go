@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIdempotency(t *testing.T) {
+	app := newTestApplication(t)
+	body := `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`
+	post := func(remoteAddr string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/v1/movies", strings.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		app.handler().ServeHTTP(w, r)
+		return w
+	}
+
+	first := post("192.0.2.1:1234", map[string]string{"Idempotency-Key": "abc", "Origin": "http://localhost:9000"})
+	if first.Code != 201 || first.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Fatalf("first request = %d with headers %v", first.Code, first.Header())
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		w := post("192.0.2.1:4321", map[string]string{"Idempotency-Key": "abc"})
+		if w.Header().Get("Idempotent-Replayed") != "true" || w.Body.String() != first.Body.String() {
+			t.Fatalf("retry was not replayed: %d %s", w.Code, w.Body)
+		}
+		if got, want := w.Header().Get("Location"), first.Header().Get("Location"); got != want {
+			t.Errorf("replayed Location = %q, want %q", got, want)
+		}
+		if got := w.Header().Values("X-Request-ID"); len(got) != 1 || got[0] == first.Header().Get("X-Request-ID") {
+			t.Errorf("replayed X-Request-ID = %q, want a new one", got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("a retry without Origin got Access-Control-Allow-Origin %q", got)
+		}
+	})
+
+	t.Run("OtherClient", func(t *testing.T) {
+		w := post("198.51.100.7:1234", map[string]string{"Idempotency-Key": "abc"})
+		if w.Code != 201 || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("another client's request with the same key = %d, replayed %q",
+				w.Code, w.Header().Get("Idempotent-Replayed"))
+		}
+	})
+}
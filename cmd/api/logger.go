@@ -1,39 +1,29 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"log/slog"
-	"os"
 )
 
-type MessageOnlyHandler struct {
-	io.Writer
-	level slog.Level
-}
-
-func (h *MessageOnlyHandler) Enabled(_ context.Context, lvl slog.Level) bool {
-	return lvl >= h.level
-}
-
-func (h *MessageOnlyHandler) Handle(_ context.Context, r slog.Record) error {
-	ts := r.Time.Format("2006/01/02 15:04:05")
-	fmt.Fprintf(h.Writer, "%s %s %s\n", ts, r.Level, r.Message)
-	return nil
-}
+const (
+	logFormatJSON   = "json"
+	logFormatLogfmt = "logfmt"
+)
 
-func (h *MessageOnlyHandler) WithAttrs(_ []slog.Attr) slog.Handler {
-	return h
-}
+// NewLogger returns a structured logger writing either JSON or logfmt records to w.
+// The level is read from the given LevelVar on every record, so it can be changed at runtime.
+func NewLogger(w io.Writer, format string, level *slog.LevelVar) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
 
-func (h *MessageOnlyHandler) WithGroup(_ string) slog.Handler {
-	return h
-}
-func NewLogger() *slog.Logger {
-	h := &MessageOnlyHandler{
-		Writer: os.Stdout,
-		level:  slog.LevelInfo,
+	var h slog.Handler
+	switch format {
+	case logFormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	case logFormatLogfmt:
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
 	}
-	return slog.New(h)
+	return slog.New(h), nil
 }
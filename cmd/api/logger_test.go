@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// logfmtPair matches a key=value pair of a logfmt record, the value being bare or quoted.
+var logfmtPair = regexp.MustCompile(`([\w.]+)=("(?:[^"\\]|\\.)*"|\S*)`)
+
+func parseLogfmt(t *testing.T, line []byte) map[string]string {
+	t.Helper()
+	record := make(map[string]string)
+	for _, m := range logfmtPair.FindAllSubmatch(line, -1) {
+		value := string(m[2])
+		if len(value) > 0 && value[0] == '"' {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				t.Fatalf("bad quoting in %s: %v", line, err)
+			}
+			value = unquoted
+		}
+		record[string(m[1])] = value
+	}
+	return record
+}
+
+func parseJSONLog(t *testing.T, line []byte) map[string]string {
+	t.Helper()
+	var raw map[string]interface{}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		t.Fatalf("bad JSON record %s: %v", line, err)
+	}
+	record := make(map[string]string)
+	for key, value := range raw {
+		if s, ok := value.(string); ok {
+			record[key] = s
+		} else {
+			b, _ := json.Marshal(value)
+			record[key] = string(b)
+		}
+	}
+	return record
+}
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		format string
+		parse  func(t *testing.T, line []byte) map[string]string
+	}{
+		{logFormatJSON, parseJSONLog},
+		{logFormatLogfmt, parseLogfmt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			var level slog.LevelVar
+			logger, err := NewLogger(&buf, tt.format, &level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app := newTestApplication(t)
+			app.logger = logger
+			app.seedMovie(t, "Alien", 1979, 117, "sci-fi")
+
+			r := httptest.NewRequest("GET", "/v1/movies/1", nil)
+			r.Header.Set("X-Request-ID", "req-1")
+			app.handler().ServeHTTP(httptest.NewRecorder(), r)
+			logger.Debug("hidden at the info level")
+			logger.Warn(`a "quoted" message`, "detail", "line one\nline two", "empty", "", "pair", "a=b c")
+			level.Set(slog.LevelDebug)
+			logger.Debug("shown at the debug level")
+
+			// A newline written unescaped would show up as an extra record.
+			lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+			if len(lines) != 3 {
+				t.Fatalf("got %d records; want 3:\n%s", len(lines), buf.String())
+			}
+
+			access := tt.parse(t, lines[0])
+			for _, key := range []string{"time", "level", "msg", "request_id", "method", "path", "status", "bytes", "duration"} {
+				if _, ok := access[key]; !ok {
+					t.Errorf("the access log record lacks %q: %s", key, lines[0])
+				}
+			}
+			if access["msg"] != "request completed" || access["level"] != "INFO" || access["request_id"] != "req-1" ||
+				access["path"] != "/v1/movies/1" || access["status"] != "200" {
+				t.Errorf("access log record = %v", access)
+			}
+
+			escaped := tt.parse(t, lines[1])
+			want := map[string]string{"msg": `a "quoted" message`, "level": "WARN", "detail": "line one\nline two", "empty": "", "pair": "a=b c"}
+			for key, value := range want {
+				if escaped[key] != value {
+					t.Errorf("%s = %q; want %q in %s", key, escaped[key], value, lines[1])
+				}
+			}
+
+			if debug := tt.parse(t, lines[2]); debug["msg"] != "shown at the debug level" || debug["level"] != "DEBUG" {
+				t.Errorf("the level change was not picked up: %v", debug)
+			}
+		})
+	}
+
+	if _, err := NewLogger(&bytes.Buffer{}, "xml", new(slog.LevelVar)); err == nil {
+		t.Error("NewLogger accepted an unknown format")
+	}
+}
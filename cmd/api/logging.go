@@ -1,14 +1,16 @@
 package main
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
+	"simplewebapi.moviedb/internal/trace"
 	"time"
 )
 
 type WrappedWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int
 }
 
 func (w *WrappedWriter) WriteHeader(code int) {
@@ -16,6 +18,12 @@ func (w *WrappedWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
+func (w *WrappedWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
 func (app *application) LoggingHTTPHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -24,6 +32,28 @@ func (app *application) LoggingHTTPHandler(next http.Handler) http.Handler {
 			statusCode:     http.StatusOK,
 		}
 		next.ServeHTTP(writer, r)
-		app.logger.Info(fmt.Sprintf("%d %s %s %s", writer.statusCode, r.Method, r.URL.Path, time.Since(start)))
+
+		attrs := append(requestAttrs(r),
+			slog.Int("status", writer.statusCode),
+			slog.Int("bytes", writer.bytes),
+			slog.Duration("duration", time.Since(start)),
+		)
+		app.logger.LogAttrs(r.Context(), slog.LevelInfo, "request completed", attrs...)
 	})
 }
+
+// requestAttrs returns the log attributes that identify a request.
+func requestAttrs(r *http.Request) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("request_id", contextGetRequestID(r)),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	}
+	if span := trace.SpanFromContext(r.Context()); span != nil {
+		attrs = append(attrs, slog.String("trace_id", span.Context.TraceID.String()))
+	}
+	if state := contextGetRequestState(r); state != nil && state.user != nil && !state.user.IsAnonymous() {
+		attrs = append(attrs, slog.Int64("user_id", state.user.ID))
+	}
+	return attrs
+}
@@ -3,93 +3,197 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
-	_ "github.com/lib/pq"
+	"fmt"
+	"github.com/lib/pq"
 	"log/slog"
 	"os"
 	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/migrate"
+	"simplewebapi.moviedb/internal/trace"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const version = "1.0.0"
 
-type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
-	}
-	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
-	}
-}
-
 type application struct {
-	config config
-	logger *slog.Logger
-	repos  data.Repo
-	wg     sync.WaitGroup
+	config  config
+	logger  *slog.Logger
+	repos   data.Repo
+	metrics *appMetrics
+	tracer  *trace.Tracer
+	wg      sync.WaitGroup
+
+	schemaVersion int64
+	shuttingDown  atomic.Bool
+
+	// Settings that can change at runtime, see reloadConfig.
+	configSource configSource
+	logLevel     *slog.LevelVar
+	rateLimit    atomic.Pointer[rateLimit]
+	cors         atomic.Pointer[corsPolicy]
+
+	// replicas is nil unless db.replica-dsns is set.
+	replicas *data.Replicas
+	// movieCache is nil when cache.size is zero.
+	movieCache *data.MovieCache
+	// responseCache is nil when http-cache.size is zero.
+	responseCache *responseCache
 }
 
 func main() {
-	var cfg config
-	//default flag value
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("MOVIE_API_DB_DSN"), "PostgreSQL DSN")
-
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
-
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
 
-	flag.Parse()
+	src, printConfig, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cfg, err := src.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
+	if printConfig {
+		cfg.print(os.Stdout)
+		return
+	}
 
-	logger := NewLogger()
+	var logLevel slog.LevelVar
+	// Both were checked by validate.
+	_ = logLevel.UnmarshalText([]byte(cfg.log.level))
+	logger, _ := NewLogger(os.Stdout, cfg.log.format, &logLevel)
 
-	db, err := openDB(cfg)
+	exporter, err := newTraceExporter(cfg)
 	if err != nil {
-		logger.Error("Failed to connect to DB: ", err)
+		logger.Error("failed to create trace exporter", "error", err)
+		os.Exit(1)
+	}
+	tracer := trace.NewTracer(exporter, func(err error) {
+		logger.Error("failed to export spans", "error", err)
+	})
+
+	var (
+		db       *sql.DB
+		repos    data.Repo
+		replicas *data.Replicas
+	)
+	switch cfg.db.driver {
+	case "memory":
+		logger.Warn("using the in-memory database, all data will be lost on exit")
+		repos = data.NewMemoryRepo()
+	default:
+		db, err = openDB(cfg)
+		if err != nil {
+			logger.Error("failed to connect to database", "error", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+		logger.Info("database connection pool established")
+
+		if cfg.autoMigrate {
+			err = autoMigrate(cfg, db, logger)
+			if err != nil {
+				logger.Error("failed to migrate database", "error", err)
+				os.Exit(1)
+			}
+		}
+		if cfg.db.driver == "sqlite" {
+			repos = data.NewSQLiteRepo(db, cfg.db.timeouts)
+		} else {
+			repos = data.NewRepo(db, cfg.db.timeouts)
+		}
+
+		if len(cfg.db.replicaDSNs) > 0 {
+			replicas, err = openReplicas(cfg)
+			if err != nil {
+				logger.Error("failed to connect to database replica", "error", err)
+				os.Exit(1)
+			}
+			defer replicas.Close()
+			logger.Info("database replica pools established", "replicas", replicas.Len())
+		}
+	}
+	repos = repos.WithTxAttempts(cfg.db.txAttempts)
+	metrics := newAppMetrics(db, replicas)
+	repos = data.Instrumented(repos, newQueryObserver(logger, metrics, cfg.db.slowQueryThreshold))
+	repos = repos.WithReplicas(replicas)
+	movieCache := newMovieCache(cfg, db, logger)
+	repos = repos.Cached(movieCache)
+	metrics.observeMovieCache(movieCache)
+
+	// The migration sets of the drivers may skip different versions.
+	migrationFS, _ := migrationSet(cfg.db.driver)
+	schemaVersion, err := migrate.LatestVersion(migrationFS)
+	if err != nil {
+		logger.Error("failed to read embedded migrations", "error", err)
+		os.Exit(1)
 	}
-	logger.Info("Database connection pool established")
-	defer db.Close()
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		repos:  data.NewRepo(db),
+		config:  cfg,
+		logger:  logger,
+		repos:   data.Traced(tracer, dbSystem(cfg.db.driver), repos),
+		metrics: metrics,
+		tracer:  tracer,
+
+		schemaVersion: schemaVersion,
+
+		configSource: src,
+		logLevel:     &logLevel,
+
+		replicas:      replicas,
+		movieCache:    movieCache,
+		responseCache: newResponseCache(cfg),
 	}
+	app.applyConfig(cfg)
 
 	err = app.serve()
 	if err != nil {
-		logger.Error(err.Error())
+		logger.Error("server error", "error", err)
 	}
 
 }
 
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
-
-	if err != nil {
-		return nil, err
+	var (
+		db  *sql.DB
+		err error
+	)
+	switch cfg.db.driver {
+	case "sqlite":
+		if sqliteDriver == "" {
+			return nil, errors.New("SQLite support is not compiled in, rebuild with -tags sqlite")
+		}
+		db, err = sql.Open(sqliteDriver, sqliteDSN(cfg.db.dsn))
+		if err != nil {
+			return nil, err
+		}
+		// SQLite allows a single writer, so the pool holds exactly one connection.
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxIdleTime(0)
+	default:
+		connector, err := pq.NewConnector(cfg.db.dsn)
+		if err != nil {
+			return nil, err
+		}
+		db = sql.OpenDB(data.WithSessionLimits(connector, map[data.QueryClass]data.SessionLimits{
+			data.ReadQuery:  {StatementTimeout: cfg.db.timeouts.Read, LockTimeout: cfg.db.lockTimeout},
+			data.WriteQuery: {StatementTimeout: cfg.db.timeouts.Write, LockTimeout: cfg.db.lockTimeout},
+		}))
+		db.SetMaxOpenConns(cfg.db.maxOpenConns)
+		db.SetMaxIdleConns(cfg.db.maxIdleConns)
+		db.SetConnMaxIdleTime(cfg.db.maxIdleTime)
 	}
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime) //parse "..m" -> .. minutes
-	if err != nil {
-		return nil, err
-	}
-	db.SetConnMaxIdleTime(duration)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -97,9 +201,50 @@ func openDB(cfg config) (*sql.DB, error) {
 	err = db.PingContext(ctx)
 
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return db, nil
 
 }
+
+// openMigrationDB opens a small pool without statement or lock timeouts, which
+// migrations, and the wait for another instance to finish them, must not be held to.
+func openMigrationDB(cfg config) (*sql.DB, error) {
+	cfg.db.maxOpenConns = 2
+	cfg.db.maxIdleConns = 2
+	cfg.db.maxIdleTime = time.Minute
+	cfg.db.timeouts = data.Timeouts{}
+	cfg.db.lockTimeout = 0
+	return openDB(cfg)
+}
+
+// autoMigrate applies the pending migrations. PostgreSQL migrations get a pool of their
+// own, free of the limits of the primary pool; SQLite has no such limits, and a second
+// connection to an in-memory database would see another database.
+func autoMigrate(cfg config, db *sql.DB, logger *slog.Logger) error {
+	if cfg.db.driver != "sqlite" {
+		var err error
+		db, err = openMigrationDB(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+
+	fsys, dialect := migrationSet(cfg.db.driver)
+	m, err := migrate.New(db, fsys)
+	if err != nil {
+		return err
+	}
+	m.Dialect = dialect
+	m.Log = func(format string, args ...interface{}) {
+		logger.Info(fmt.Sprintf(format, args...))
+	}
+	err = m.Up(context.Background())
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
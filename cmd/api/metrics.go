@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"runtime"
+	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type appMetrics struct {
+	registry *metrics.Registry
+
+	requests          *metrics.CounterVec
+	requestDuration   *metrics.HistogramVec
+	requestsInFlight  *metrics.Value
+	rateLimited       *metrics.Value
+	panicsRecovered   *metrics.Value
+	backgroundTasks   atomic.Int64
+	backgroundStarted *metrics.Value
+	queries           *metrics.CounterVec
+	queryDuration     *metrics.HistogramVec
+
+	responseCacheHits   *metrics.Value
+	responseCacheMisses *metrics.Value
+}
+
+func newAppMetrics(db *sql.DB, replicas *data.Replicas) *appMetrics {
+	reg := metrics.NewRegistry()
+	m := &appMetrics{
+		registry: reg,
+		requests: reg.NewCounter("moviedb_http_requests_total",
+			"Total number of HTTP requests by route, method and status.", "route", "method", "status"),
+		requestDuration: reg.NewHistogram("moviedb_http_request_duration_seconds",
+			"HTTP request latency by route, method and status.", metrics.DefBuckets, "route", "method", "status"),
+		requestsInFlight: reg.NewGauge("moviedb_http_requests_in_flight",
+			"Number of HTTP requests currently being served.").With(),
+		rateLimited: reg.NewCounter("moviedb_rate_limit_rejections_total",
+			"Number of requests rejected by the rate limiter.").With(),
+		panicsRecovered: reg.NewCounter("moviedb_panics_recovered_total",
+			"Number of panics recovered while serving requests.").With(),
+		backgroundStarted: reg.NewCounter("moviedb_background_tasks_started_total",
+			"Number of background tasks started.").With(),
+		queries: reg.NewCounter("moviedb_db_queries_total",
+			"Number of database queries by normalized query, class and outcome.", "query", "class", "outcome"),
+		queryDuration: reg.NewHistogram("moviedb_db_query_duration_seconds",
+			"Database query latency by normalized query and class.", metrics.DefBuckets, "query", "class"),
+		responseCacheHits: reg.NewCounter("moviedb_http_response_cache_hits_total",
+			"Number of anonymous GET requests served from the response cache.").With(),
+		responseCacheMisses: reg.NewCounter("moviedb_http_response_cache_misses_total",
+			"Number of anonymous GET requests that missed the response cache.").With(),
+	}
+	reg.NewGaugeFunc("moviedb_background_tasks_running", "Number of background tasks currently running.", func() float64 {
+		return float64(m.backgroundTasks.Load())
+	})
+	reg.NewInfo("moviedb_build_info", "Build information about the running binary.", map[string]string{
+		"version":   version,
+		"goversion": runtime.Version(),
+	})
+
+	if db != nil {
+		stat := func(fn func(s sql.DBStats) float64) func() float64 {
+			return func() float64 { return fn(db.Stats()) }
+		}
+		reg.NewGaugeFunc("moviedb_db_max_open_connections", "Maximum number of open connections to the database.",
+			stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+		reg.NewGaugeFunc("moviedb_db_open_connections", "Number of established connections, both in use and idle.",
+			stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+		reg.NewGaugeFunc("moviedb_db_in_use_connections", "Number of connections currently in use.",
+			stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+		reg.NewGaugeFunc("moviedb_db_idle_connections", "Number of idle connections.",
+			stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+		reg.NewCounterFunc("moviedb_db_wait_count_total", "Total number of connections waited for.",
+			stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+		reg.NewCounterFunc("moviedb_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.",
+			stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+		reg.NewCounterFunc("moviedb_db_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.",
+			stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+		reg.NewCounterFunc("moviedb_db_max_idle_time_closed_total", "Total number of connections closed due to SetConnMaxIdleTime.",
+			stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+		reg.NewCounterFunc("moviedb_db_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime.",
+			stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+	}
+	if replicas != nil {
+		reg.NewGaugeFunc("moviedb_db_replicas", "Number of configured read replicas.",
+			func() float64 { return float64(replicas.Len()) })
+		reg.NewGaugeFunc("moviedb_db_replicas_healthy", "Number of read replicas that passed their last health check.",
+			func() float64 { return float64(replicas.Healthy()) })
+	}
+	return m
+}
+
+// observeMovieCache exports the statistics of c, unless it is nil.
+func (m *appMetrics) observeMovieCache(c *data.MovieCache) {
+	if c == nil {
+		return
+	}
+	m.registry.NewCounterFunc("moviedb_movie_cache_hits_total", "Number of movie reads served from the cache.",
+		func() float64 { return float64(c.Stats().Hits) })
+	m.registry.NewCounterFunc("moviedb_movie_cache_misses_total", "Number of movie reads that missed the cache.",
+		func() float64 { return float64(c.Stats().Misses) })
+	m.registry.NewGaugeFunc("moviedb_movie_cache_entries", "Number of movies and pages of movie listings in the cache.",
+		func() float64 {
+			stats := c.Stats()
+			return float64(stats.Movies + stats.Lists)
+		})
+}
+
+func (app *application) Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		writer := &WrappedWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		app.metrics.requestsInFlight.Inc()
+		defer app.metrics.requestsInFlight.Dec()
+
+		next.ServeHTTP(writer, r)
+
+		route := "unmatched"
+		if state := contextGetRequestState(r); state != nil && state.route != "" {
+			route = state.route
+		}
+		status := strconv.Itoa(writer.statusCode)
+		app.metrics.requests.With(route, r.Method, status).Inc()
+		app.metrics.requestDuration.With(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// recordRoute stores the pattern the router matched, such as "/v1/movies/{id}", so
+// that metrics can be grouped by route without exploding on every distinct ID.
+func recordRoute(prefix string, next *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if state := contextGetRequestState(r); state != nil && r.Pattern != "" {
+			pattern := r.Pattern
+			if i := strings.IndexByte(pattern, ' '); i >= 0 {
+				pattern = pattern[i+1:]
+			}
+			state.route = prefix + pattern
+		}
+	})
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"golang.org/x/time/rate"
 	"net/http"
 	"simplewebapi.moviedb/internal/data"
 	"simplewebapi.moviedb/internal/validator"
@@ -23,10 +24,45 @@ func CreateChain(m ...Middleware) Middleware {
 
 }
 
+// RequestID tags every request with an ID, reusing a well-formed X-Request-ID sent by
+// the client or generating a new one, and echoes it back in the response header.
+func (app *application) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+		r = app.contextSetRequestState(r)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func (app *application) RecoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
+				app.metrics.panicsRecovered.Inc()
 				w.Header().Set("Connection", "close")
 
 				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
@@ -39,9 +75,10 @@ func (app *application) RecoverPanic(next http.Handler) http.Handler {
 }
 
 func (app *application) RateLimit(next http.Handler) http.Handler {
-	limiter := rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled && !limiter.Allow() {
+		limit := app.rateLimit.Load()
+		if limit.enabled && !limit.limiter.Allow() {
+			app.metrics.rateLimited.Inc()
 			app.rateLimitExceededResponse(w, r)
 			return
 		}
@@ -50,7 +87,7 @@ func (app *application) RateLimit(next http.Handler) http.Handler {
 }
 func (app *application) authenticate(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Vary", "Authentication")
+		w.Header().Add("Vary", "Authorization")
 
 		authorizationHeader := r.Header.Get("Authorization")
 
@@ -75,7 +112,7 @@ func (app *application) authenticate(next http.Handler) http.HandlerFunc {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
-		user, err := app.repos.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.repos.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 
 		if err != nil {
 			switch {
@@ -87,35 +124,8 @@ func (app *application) authenticate(next http.Handler) http.HandlerFunc {
 			return
 		}
 
-		app.contextSetUser(r, user)
+		r = app.contextSetUser(r, user)
 
 		next.ServeHTTP(w, r)
 	})
 }
-
-func (app *application) CORSMiddleware(list ...string) Middleware {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Add("Vary", "Origin")
-			w.Header().Add("Vary", "Access-Control-Request-Method")
-			origin := r.Header.Get("Origin")
-			if origin != "" {
-				for _, site := range list {
-					if site == origin {
-						w.Header().Set("Access-Control-Allow-Origin", site)
-					}
-					//response preflight request
-					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-						w.WriteHeader(http.StatusOK)
-						return
-					}
-				}
-
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-}
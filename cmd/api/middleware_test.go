@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	tests := []struct {
+		name string
+		sent string
+		echo bool // whether the sent ID is kept
+	}{
+		{"Missing", "", false},
+		{"Valid", "client-42_a.b:c", true},
+		{"MaxLength", strings.Repeat("a", 128), true},
+		{"TooLong", strings.Repeat("a", 129), false},
+		{"Spaces", "two words", false},
+		{"HeaderInjection", "abc\r\nSet-Cookie: x=1", false},
+		{"NonASCII", "ïd", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			var logs bytes.Buffer
+			app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+			app.repos.Movies = failingMovies{}
+
+			req := testRequest{method: "GET", path: "/v1/movies/1", header: map[string]string{}}
+			if tt.sent != "" {
+				req.header["X-Request-ID"] = tt.sent
+			}
+			w := app.do(t, req)
+
+			id := w.Header().Get("X-Request-ID")
+			switch {
+			case tt.echo && id != tt.sent:
+				t.Fatalf("X-Request-ID = %q; want the ID sent, %q", id, tt.sent)
+			case !tt.echo && !generated.MatchString(id):
+				t.Fatalf("X-Request-ID = %q; want a generated ID", id)
+			}
+
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status %d; want 500", w.Code)
+			}
+			var body struct {
+				RequestID string `json:"request_id"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.RequestID != id {
+				t.Errorf("the 500 body carries request ID %q; want %q", body.RequestID, id)
+			}
+
+			var logged int
+			for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+				var record struct {
+					Msg       string `json:"msg"`
+					RequestID string `json:"request_id"`
+				}
+				if err := json.Unmarshal(line, &record); err != nil {
+					t.Fatalf("log line %s: %v", line, err)
+				}
+				if record.Msg == "request failed" && record.RequestID == id {
+					logged++
+				}
+			}
+			if logged != 1 {
+				t.Errorf("the error log does not carry request ID %q:\n%s", id, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"simplewebapi.moviedb/internal/migrate"
+	"strconv"
+)
+
+const migrateUsage = `Usage: api migrate [flags] <command>
+
+Commands:
+  up            apply all pending migrations
+  down [N]      roll back the last N migrations (default 1)
+  goto V        migrate up or down to version V
+  status        show the current version and every known migration
+  force V       set the version to V and clear the dirty flag without running anything
+
+The database is chosen by the same configuration as the server: defaults, then
+-config file, then %s* variables, then flags.
+
+Flags:
+`
+
+// runMigrate implements the "migrate" subcommand and returns the process exit code.
+// It loads the configuration the same way the server does, so that -config, the
+// environment and the flags all choose the database to migrate.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	src := configFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), migrateUsage, envPrefix)
+		printFlags(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := src.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		return 2
+	}
+	if cfg.db.driver != "postgres" && cfg.db.driver != "sqlite" {
+		fmt.Fprintf(os.Stderr, "database driver %q has no migrations\n", cfg.db.driver)
+		return 2
+	}
+
+	db, err := openMigrationDB(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to connect to database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	fsys, dialect := migrationSet(cfg.db.driver)
+	m, err := migrate.New(db, fsys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	m.Dialect = dialect
+	m.Log = func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+	}
+
+	ctx := context.Background()
+	command, rest := fs.Arg(0), fs.Args()[1:]
+	switch command {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		steps := 1
+		if len(rest) > 0 {
+			steps, err = strconv.Atoi(rest[0])
+			if err != nil || steps < 1 {
+				fmt.Fprintf(os.Stderr, "invalid number of steps %q\n", rest[0])
+				return 2
+			}
+		}
+		err = m.Down(ctx, steps)
+	case "goto", "force":
+		if len(rest) != 1 {
+			fs.Usage()
+			return 2
+		}
+		version, perr := strconv.ParseInt(rest[0], 10, 64)
+		if perr != nil || version < 0 {
+			fmt.Fprintf(os.Stderr, "invalid version %q\n", rest[0])
+			return 2
+		}
+		if command == "goto" {
+			err = m.Goto(ctx, version)
+		} else {
+			err = m.Force(ctx, version)
+		}
+	case "status":
+		var status migrate.Status
+		status, err = m.Status(ctx)
+		if err == nil {
+			printMigrationStatus(status)
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		fmt.Println("no change")
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus(status migrate.Status) {
+	dirty := ""
+	if status.Dirty {
+		dirty = " (dirty)"
+	}
+	fmt.Printf("current version: %d%s\n", status.Version, dirty)
+	for _, mig := range status.Migrations {
+		state := "pending"
+		if mig.Applied {
+			state = "applied"
+		}
+		fmt.Printf("  %06d  %-8s %s\n", mig.Version, state, mig.Name)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"mime"
 	"net/http"
 	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/jsonpatch"
 	"simplewebapi.moviedb/internal/validator"
 )
 
@@ -39,14 +44,15 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.repos.Movies.Insert(&movie)
+	err = app.repos.Movies.Insert(r.Context(), &movie)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.dbErrorResponse(w, r, err)
 		return
 	}
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(&movie))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
@@ -60,7 +66,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
-	movie, err := app.repos.Movies.Get(id)
+	movie, err := app.repos.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -70,8 +76,19 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	etag := movieETag(movie)
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+	headers.Set("Last-Modified", movie.UpdatedAt.UTC().Format(http.TimeFormat))
+	headers.Set("Cache-Control", cacheControl(true, app.config.httpCache.maxAge))
+	if notModified(r, etag, movie.UpdatedAt) {
+		maps.Copy(w.Header(), headers)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	data := envelope{"movie": movie}
-	err = app.writeJSON(w, http.StatusOK, data, nil)
+	err = app.writeJSON(w, http.StatusOK, data, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -83,7 +100,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.notFoundResponse(w, r)
 		return
 	}
-	movie, err := app.repos.Movies.Get(id)
+	movie, err := app.repos.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -93,16 +110,47 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	var input MovieInput
+	match := r.Header.Get("If-Match")
+	if match != "" && !etagMatch(match, movieETag(movie), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
 
-	err = app.readJSON(w, r, &input)
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "", "application/json":
+		var input MovieInput
 
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+		err = app.readJSON(w, r, &input)
+
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		movieMapper(input, movie)
+	case mediaTypeMergePatch, mediaTypeJSONPatch:
+		body, err := app.readBody(w, r)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		patched, err := patchMovie(contentType, movie, body)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrInvalidPatch):
+				app.badRequestResponse(w, r, err)
+			default:
+				app.failedValidationResponse(w, r, map[string]string{"patch": err.Error()})
+			}
+			return
+		}
+		movie = patched
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
 		return
 	}
 
-	movieMapper(input, movie)
 	v := validator.New()
 
 	if !data.ValidateMovie(v, movie) {
@@ -110,18 +158,23 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.repos.Movies.Update(movie)
+	err = app.repos.Movies.Update(r.Context(), movie)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && match != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.dbErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -133,13 +186,36 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.repos.Movies.Delete(id)
+	if match := r.Header.Get("If-Match"); match != "" {
+		var movie *data.Movie
+		movie, err = app.repos.Movies.Get(r.Context(), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.preconditionFailedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if !etagMatch(match, movieETag(movie), false) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+		// The movie may change after the check above, so it is only deleted at the
+		// version that matched.
+		err = app.repos.Movies.DeleteVersion(r.Context(), id, movie.Version)
+	} else {
+		err = app.repos.Movies.Delete(r.Context(), id)
+	}
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.dbErrorResponse(w, r, err)
 		}
 		return
 	}
@@ -170,17 +246,66 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	movies, metadata, err := app.repos.Movies.GetAll(input.Title, input.Genres, input.Filter)
+	movies, metadata, err := app.repos.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filter)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	// Listing needs authentication, so only the client itself may keep the response.
+	headers := make(http.Header)
+	headers.Set("Cache-Control", cacheControl(false, app.config.httpCache.maxAge))
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
+
+const (
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+// patchMovie applies an RFC 7396 merge patch or an RFC 6902 JSON patch to the JSON
+// representation of movie and decodes the result into a new movie.
+// The id and version of the movie cannot be changed through a patch.
+func patchMovie(contentType string, movie *data.Movie, body []byte) (*data.Movie, error) {
+	doc, err := json.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+	switch contentType {
+	case mediaTypeMergePatch:
+		doc, err = jsonpatch.MergePatch(doc, body)
+	case mediaTypeJSONPatch:
+		var patch jsonpatch.Patch
+		patch, err = jsonpatch.DecodePatch(body)
+		if err == nil {
+			doc, err = patch.Apply(doc)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var patched data.Movie
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&patched)
+	if err != nil {
+		return nil, fmt.Errorf("patched movie is invalid: %w", err)
+	}
+	if patched.ID != movie.ID {
+		return nil, errors.New("id must not be modified")
+	}
+	if patched.Version != movie.Version {
+		return nil, errors.New("version must not be modified")
+	}
+	patched.CreatedAt = movie.CreatedAt
+	patched.UpdatedAt = movie.UpdatedAt
+	return &patched, nil
+}
+
 func movieMapper(input MovieInput, movie *data.Movie) {
 
 	if input.Title != nil {
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/validator"
+)
+
+const maxBatchOperations = 100
+
+type BatchOperationInput struct {
+	Op      string     `json:"op"`
+	ID      int64      `json:"id"`
+	Version *int32     `json:"version"`
+	Movie   MovieInput `json:"movie"`
+}
+
+type BatchResult struct {
+	Index   int         `json:"index"`
+	Op      string      `json:"op"`
+	Status  int         `json:"status"`
+	Movie   *data.Movie `json:"movie,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Error   interface{} `json:"error,omitempty"`
+
+	err error // the error the operation failed with, if it came from the database
+}
+
+func (res BatchResult) failed() bool {
+	return res.Status >= http.StatusBadRequest
+}
+
+var errBatchAborted = errors.New("batch aborted")
+
+// batchMovieHandler applies a list of create, update and delete operations.
+// In atomic mode (the default) every operation runs in one transaction and nothing is
+// applied unless all of them succeed; the response has the status of the operation
+// that failed, if any. In partial mode each operation stands on its own, and the
+// response is 207 Multi-Status when some of them failed, since the client must then
+// look at each result.
+func (app *application) batchMovieHandler(w http.ResponseWriter, r *http.Request) {
+	mode := readString(r.URL.Query(), "mode", "atomic")
+
+	var input []BatchOperationInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.In(mode, "atomic", "partial"), "mode", "must be either atomic or partial")
+	v.Check(len(input) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]BatchResult, len(input))
+	status := http.StatusOK
+
+	switch mode {
+	case "partial":
+		for i, op := range input {
+			results[i] = app.runBatchOperation(r, app.repos, i, op)
+			if results[i].failed() {
+				status = http.StatusMultiStatus
+			}
+		}
+	case "atomic":
+		err = app.repos.WithTx(r.Context(), func(tx data.Repo) error {
+			return app.runAtomicBatch(r, tx, input, results)
+		})
+		if err != nil && !errors.Is(err, errBatchAborted) {
+			app.dbErrorResponse(w, r, err)
+			return
+		}
+		if err != nil {
+			for i := range results {
+				if results[i].failed() {
+					status = results[i].Status
+				} else {
+					results[i] = BatchResult{Index: i, Op: input[i].Op, Status: http.StatusFailedDependency,
+						Error: "operation not applied because another operation in the batch failed"}
+				}
+			}
+		}
+	}
+
+	err = app.writeJSON(w, status, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runAtomicBatch runs the operations in order until one of them fails. The error it
+// then returns wraps errBatchAborted and the error of the operation, so that WithTx
+// retries the batch when the database aborted the transaction.
+func (app *application) runAtomicBatch(r *http.Request, tx data.Repo, input []BatchOperationInput, results []BatchResult) error {
+	// Results of an earlier attempt must not survive a retry.
+	clear(results)
+	for i, op := range input {
+		results[i] = app.runBatchOperation(r, tx, i, op)
+		if results[i].failed() {
+			if results[i].err != nil {
+				return fmt.Errorf("%w: %w", errBatchAborted, results[i].err)
+			}
+			return errBatchAborted
+		}
+	}
+	return nil
+}
+
+func (app *application) runBatchOperation(r *http.Request, repos data.Repo, index int, op BatchOperationInput) BatchResult {
+	result := BatchResult{Index: index, Op: op.Op}
+	fail := func(status int, message interface{}) BatchResult {
+		result.Status = status
+		result.Error = message
+		return result
+	}
+
+	switch op.Op {
+	case "create":
+		var movie data.Movie
+		movieMapper(op.Movie, &movie)
+		v := validator.New()
+		if !data.ValidateMovie(v, &movie) {
+			return fail(http.StatusUnprocessableEntity, v.Errors)
+		}
+		err := repos.Movies.Insert(r.Context(), &movie)
+		if err != nil {
+			return app.batchErrorResult(r, result, err)
+		}
+		result.Status = http.StatusCreated
+		result.Movie = &movie
+
+	case "update":
+		movie, err := repos.Movies.Get(r.Context(), op.ID)
+		if err != nil {
+			return app.batchErrorResult(r, result, err)
+		}
+		if op.Version != nil && *op.Version != movie.Version {
+			return app.batchErrorResult(r, result, data.ErrEditConflict)
+		}
+		movieMapper(op.Movie, movie)
+		v := validator.New()
+		if !data.ValidateMovie(v, movie) {
+			return fail(http.StatusUnprocessableEntity, v.Errors)
+		}
+		err = repos.Movies.Update(r.Context(), movie)
+		if err != nil {
+			return app.batchErrorResult(r, result, err)
+		}
+		result.Status = http.StatusOK
+		result.Movie = movie
+
+	case "delete":
+		err := repos.Movies.Delete(r.Context(), op.ID)
+		if err != nil {
+			return app.batchErrorResult(r, result, err)
+		}
+		result.Status = http.StatusOK
+		result.Message = "movie successfully deleted"
+
+	default:
+		return fail(http.StatusUnprocessableEntity, map[string]string{"op": "must be one of create, update or delete"})
+	}
+	return result
+}
+
+func (app *application) batchErrorResult(r *http.Request, result BatchResult, err error) BatchResult {
+	result.err = err
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		result.Status = http.StatusNotFound
+		result.Error = "resource not found"
+	case errors.Is(err, data.ErrEditConflict):
+		result.Status = http.StatusConflict
+		result.Error = "unable to update the record due to an edit conflict, please try again"
+	default:
+		if status, _, message, ok := dbErrorStatus(err); ok {
+			result.Status = status
+			result.Error = message
+			return result
+		}
+		app.logError(r, err)
+		result.Status = http.StatusInternalServerError
+		result.Error = "the server encountered a problem and could not process your request"
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"simplewebapi.moviedb/internal/data"
+	"testing"
+)
+
+func TestBatchMovies(t *testing.T) {
+	tests := []struct {
+		name string
+		req  testRequest
+	}{
+		{name: "Atomic", req: testRequest{method: "POST", path: "/v1/movies/batch", body: `[
+			{"op": "create", "movie": {"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}},
+			{"op": "update", "id": 1, "version": 1, "movie": {"year": 1980}},
+			{"op": "delete", "id": 2}]`}},
+		{name: "AtomicFailure", req: testRequest{method: "POST", path: "/v1/movies/batch", body: `[
+			{"op": "create", "movie": {"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}},
+			{"op": "update", "id": 1, "version": 7, "movie": {"year": 1980}},
+			{"op": "delete", "id": 2}]`}},
+		{name: "Partial", req: testRequest{method: "POST", path: "/v1/movies/batch?mode=partial", body: `[
+			{"op": "delete", "id": 2},
+			{"op": "delete", "id": 42},
+			{"op": "rename", "id": 1}]`}},
+		{name: "PartialSuccess", req: testRequest{method: "POST", path: "/v1/movies/batch?mode=partial", body: `[
+			{"op": "delete", "id": 1},
+			{"op": "delete", "id": 2}]`}},
+		{name: "Invalid", req: testRequest{method: "POST", path: "/v1/movies/batch?mode=eventual", body: `[]`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.seedMovie(t, "Alien", 1979, 117, "sci-fi", "horror")
+			app.seedMovie(t, "Star Wars", 1977, 121, "sci-fi", "adventure")
+			checkGolden(t, app.do(t, tt.req))
+		})
+	}
+}
+
+func TestBatchMoviesIdempotent(t *testing.T) {
+	app := newTestApplication(t)
+	app.seedMovie(t, "Alien", 1979, 117, "sci-fi", "horror")
+	req := testRequest{method: "POST", path: "/v1/movies/batch", body: `[
+		{"op": "create", "movie": {"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}},
+		{"op": "delete", "id": 1}]`,
+		header: map[string]string{"Idempotency-Key": "batch-1"}}
+
+	first := app.do(t, req)
+	retry := app.do(t, req)
+	if retry.Code != first.Code || retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %s, replayed %q; want the first response replayed",
+			retry.Code, retry.Body, retry.Header().Get("Idempotent-Replayed"))
+	}
+	_, metadata, err := app.repos.Movies.GetAll(context.Background(), "", nil, data.Filter{Page: 1, PageSize: 20, Sort: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.TotalRecords != 1 {
+		t.Errorf("got %d movies after a retried batch; want 1", metadata.TotalRecords)
+	}
+}
+
+// conflictingMovies fails every Insert as if the transaction had been aborted by a
+// concurrent one.
+type conflictingMovies struct {
+	data.MoviesRepoInterface
+}
+
+func (conflictingMovies) Insert(context.Context, *data.Movie) error {
+	return data.ErrSerializationFailure
+}
+
+func TestRunAtomicBatch(t *testing.T) {
+	app := newTestApplication(t)
+	tx := data.Repo{Movies: conflictingMovies{}}
+	title, year, runtime := "Moana", int32(2016), data.Runtime(107)
+	input := []BatchOperationInput{
+		{Op: "create", Movie: MovieInput{Title: &title, Year: &year, Runtime: &runtime, Genres: []string{"animation"}}},
+	}
+	results := make([]BatchResult, len(input))
+
+	err := app.runAtomicBatch(httptest.NewRequest("POST", "/v1/movies/batch", nil), tx, input, results)
+	if !errors.Is(err, data.ErrSerializationFailure) || !errors.Is(err, errBatchAborted) {
+		t.Errorf("got %v; want the serialization failure, so that WithTx retries the batch", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"simplewebapi.moviedb/internal/data"
+	"time"
+)
+
+// newQueryObserver returns the observer of data.Instrumented. It counts every query
+// by its normalized text and logs those slower than threshold, unless threshold is zero.
+func newQueryObserver(logger *slog.Logger, m *appMetrics, threshold time.Duration) func(context.Context, data.QueryStat) {
+	return func(ctx context.Context, stat data.QueryStat) {
+		outcome := "ok"
+		if stat.Err != nil && !errors.Is(stat.Err, sql.ErrNoRows) {
+			outcome = "error"
+		}
+		m.queries.With(stat.Query, string(stat.Class), outcome).Inc()
+		m.queryDuration.With(stat.Query, string(stat.Class)).Observe(stat.Duration.Seconds())
+
+		if threshold == 0 || stat.Duration < threshold {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("query", stat.Query),
+			slog.String("class", string(stat.Class)),
+			slog.Duration("duration", stat.Duration),
+		}
+		if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		if stat.Err != nil {
+			attrs = append(attrs, slog.String("error", stat.Err.Error()))
+		}
+		logger.LogAttrs(ctx, slog.LevelWarn, "slow query", attrs...)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"golang.org/x/time/rate"
+	"log/slog"
+)
+
+// reloadable lists the settings that a SIGHUP applies to the running server. Any other
+// change needs a restart.
+var reloadable = map[string]bool{
+	"limiter.rps":            true,
+	"limiter.burst":          true,
+	"limiter.enabled":        true,
+	"cors.trusted-origins":   true,
+	"cors.allowed-methods":   true,
+	"cors.allowed-headers":   true,
+	"cors.exposed-headers":   true,
+	"cors.allow-credentials": true,
+	"cors.max-age":           true,
+	"log.level":              true,
+}
+
+// rateLimit is swapped as a whole so that requests never see a limiter built from the
+// rps of one configuration and the burst of another.
+type rateLimit struct {
+	limiter *rate.Limiter
+	enabled bool
+}
+
+// applyConfig publishes the reloadable settings of cfg to the running server.
+func (app *application) applyConfig(cfg config) {
+	current := app.rateLimit.Load()
+	if current == nil || current.limiter.Limit() != rate.Limit(cfg.limiter.rps) || current.limiter.Burst() != cfg.limiter.burst {
+		app.rateLimit.Store(&rateLimit{
+			limiter: rate.NewLimiter(rate.Limit(cfg.limiter.rps), cfg.limiter.burst),
+			enabled: cfg.limiter.enabled,
+		})
+	} else if current.enabled != cfg.limiter.enabled {
+		app.rateLimit.Store(&rateLimit{limiter: current.limiter, enabled: cfg.limiter.enabled})
+	}
+
+	app.cors.Store(newCORSPolicy(cfg))
+
+	// The level was checked by validate.
+	_ = app.logLevel.UnmarshalText([]byte(cfg.log.level))
+}
+
+// reloadConfig reads the configuration again from the sources used at startup and
+// applies the reloadable changes. Changes to other settings are logged and ignored.
+// It returns the configuration now in effect.
+func (app *application) reloadConfig(current config) config {
+	next, err := app.configSource.load()
+	if err != nil {
+		app.logger.Error("configuration reload failed, keeping the current configuration", "error", err)
+		return current
+	}
+
+	var applied []any
+	var rejected []string
+	currentSettings, nextSettings := current.settings(), next.settings()
+	for i, s := range currentSettings {
+		value := nextSettings[i].get()
+		if s.get() == value {
+			continue
+		}
+		if !reloadable[s.key] {
+			rejected = append(rejected, s.key)
+			continue
+		}
+		_ = s.set(value)
+		applied = append(applied, slog.String(s.key, value))
+	}
+
+	if len(rejected) > 0 {
+		app.logger.Warn("configuration changes need a restart and were ignored", "settings", rejected)
+	}
+	if len(applied) == 0 {
+		app.logger.Info("configuration reloaded, nothing to apply")
+		return current
+	}
+	app.applyConfig(current)
+	app.logger.Info("configuration reloaded", slog.Group("applied", applied...))
+	return current
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("db:\n  driver: memory\nlimiter:\n  enabled: false\n")
+
+	app := newTestApplication(t)
+	var logs bytes.Buffer
+	app.logger = slog.New(slog.NewTextHandler(&logs, nil))
+	app.configSource = configSource{file: path}
+	cfg, err := app.configSource.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.config = cfg
+	app.applyConfig(cfg)
+
+	write(`db:
+  driver: memory
+  dsn: postgres://elsewhere/movies
+port: 5000
+limiter:
+  enabled: true
+  rps: 1
+  burst: 1
+cors:
+  trusted_origins: ["https://*.example.com"]
+log:
+  level: debug
+`)
+	cfg = app.reloadConfig(cfg)
+
+	if limit := app.rateLimit.Load(); !limit.enabled || limit.limiter.Burst() != 1 || limit.limiter.Limit() != 1 {
+		t.Errorf("the limiter was not reloaded: enabled %t, rps %v, burst %d", limit.enabled, limit.limiter.Limit(), limit.limiter.Burst())
+	}
+	if policy := app.cors.Load(); !policy.allowOrigin("https://api.example.com") || policy.allowOrigin("http://localhost:9000") {
+		t.Error("the CORS origins were not reloaded")
+	}
+	if app.logLevel.Level() != slog.LevelDebug {
+		t.Errorf("log level %v after the reload; want debug", app.logLevel.Level())
+	}
+	if !cfg.limiter.enabled || cfg.limiter.burst != 1 || cfg.log.level != "debug" {
+		t.Errorf("the returned configuration lacks the reloaded settings: %+v", cfg.limiter)
+	}
+
+	if cfg.port != 4000 || cfg.db.dsn != "" {
+		t.Errorf("settings that need a restart were applied: port %d, db.dsn %q", cfg.port, cfg.db.dsn)
+	}
+	if !strings.Contains(logs.String(), "need a restart") || !strings.Contains(logs.String(), "db.dsn") ||
+		!strings.Contains(logs.String(), "port") {
+		t.Errorf("the ignored settings were not logged:\n%s", logs.String())
+	}
+
+	// An invalid file leaves the running configuration alone.
+	limit, policy := app.rateLimit.Load(), app.cors.Load()
+	for _, content := range []string{"db:\n  driver: memory\nlimiter:\n  rps: -1\n", "port 4000\n"} {
+		logs.Reset()
+		write(content)
+		if got := app.reloadConfig(cfg); got.limiter != cfg.limiter || got.log != cfg.log {
+			t.Errorf("a failed reload changed the configuration to %+v", got.limiter)
+		}
+		if app.rateLimit.Load() != limit || app.cors.Load() != policy || app.logLevel.Level() != slog.LevelDebug {
+			t.Error("a failed reload changed the running settings")
+		}
+		if !strings.Contains(logs.String(), "reload failed") {
+			t.Errorf("the failed reload was not logged:\n%s", logs.String())
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"simplewebapi.moviedb/internal/data"
+	"strconv"
+	"time"
+)
+
+// readPrimaryCookie holds the time, in Unix milliseconds, until which a client that
+// wrote should read from the primary, so that it sees its own writes despite replica lag.
+// Clients that do not keep cookies send the same value back in readPrimaryHeader.
+const (
+	readPrimaryCookie = "moviedb_read_primary_until"
+	readPrimaryHeader = "Read-Primary-Until"
+)
+
+// readsOwnWrites reports whether the client of r is within a read-your-writes window,
+// whose end it sends in readPrimaryHeader or else in readPrimaryCookie. The value comes
+// from the client, so an end further than window from now, which was never issued, is
+// ignored rather than letting the client pin its reads to the primary.
+func readsOwnWrites(r *http.Request, now time.Time, window time.Duration) bool {
+	value := r.Header.Get(readPrimaryHeader)
+	if value == "" {
+		cookie, err := r.Cookie(readPrimaryCookie)
+		if err != nil {
+			return false
+		}
+		value = cookie.Value
+	}
+	until, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false
+	}
+	return now.UnixMilli() < until && until <= now.Add(window).UnixMilli()
+}
+
+// openReplicas connects to every replica with the pool settings of the primary.
+func openReplicas(cfg config) (*data.Replicas, error) {
+	var dbs []*sql.DB
+	for i, dsn := range cfg.db.replicaDSNs {
+		replicaCfg := cfg
+		replicaCfg.db.dsn = dsn
+		db, err := openDB(replicaCfg)
+		if err != nil {
+			for _, db := range dbs {
+				db.Close()
+			}
+			return nil, fmt.Errorf("replica %d: %w", i, err)
+		}
+		dbs = append(dbs, db)
+	}
+	return data.NewReplicas(dbs...), nil
+}
+
+// ReadYourWrites routes the reads of a request to the primary when the client wrote
+// within the last db.read-your-writes-window, and starts that window on every write by
+// setting readPrimaryCookie and readPrimaryHeader.
+func (app *application) ReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		window := app.config.db.readYourWritesWindow
+		if app.replicas == nil || window == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if readsOwnWrites(r, now, window) {
+				r = r.WithContext(data.UsePrimary(r.Context()))
+			}
+		default:
+			until := strconv.FormatInt(now.Add(window).UnixMilli(), 10)
+			w.Header().Set(readPrimaryHeader, until)
+			http.SetCookie(w, &http.Cookie{
+				Name:     readPrimaryCookie,
+				Value:    until,
+				Path:     "/v1",
+				MaxAge:   int(window.Round(time.Second) / time.Second),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// monitorReplicas checks the replicas every db.replica-check-interval until done is
+// closed, logging every replica that goes down or comes back.
+func (app *application) monitorReplicas(done <-chan struct{}) {
+	app.replicas.Monitor(app.config.db.replicaCheckInterval, app.config.health.timeout, done, func(index int, healthy bool, err error) {
+		if healthy {
+			app.logger.Info("database replica is back in rotation", "replica", index)
+			return
+		}
+		app.logger.Warn("database replica is down, its reads go elsewhere", "replica", index, "error", err)
+	})
+}
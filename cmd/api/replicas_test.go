@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"simplewebapi.moviedb/internal/data"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReadYourWrites(t *testing.T) {
+	app := newTestApplication(t)
+	app.replicas = data.NewReplicas()
+	app.config.db.readYourWritesWindow = time.Minute
+
+	var usedPrimary bool
+	handler := app.ReadYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usedPrimary = data.UsesPrimary(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/movies", nil))
+	if usedPrimary {
+		t.Error("a client that never wrote read from the primary")
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("a read set the read-your-writes cookie")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/movies", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != readPrimaryCookie {
+		t.Fatalf("a write set the cookies %v, want %s", cookies, readPrimaryCookie)
+	}
+
+	r := httptest.NewRequest("GET", "/v1/movies", nil)
+	r.AddCookie(cookies[0])
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !usedPrimary {
+		t.Error("a read right after a write did not use the primary")
+	}
+
+	r = httptest.NewRequest("GET", "/v1/movies", nil)
+	r.AddCookie(&http.Cookie{Name: readPrimaryCookie, Value: "1"})
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if usedPrimary {
+		t.Error("a read after the window had passed used the primary")
+	}
+
+	// Clients without cookies send the window back in a header.
+	until := w.Header().Get(readPrimaryHeader)
+	if until != cookies[0].Value {
+		t.Fatalf("a write set %s to %q, want the cookie value %q", readPrimaryHeader, until, cookies[0].Value)
+	}
+	r = httptest.NewRequest("GET", "/v1/movies", nil)
+	r.Header.Set(readPrimaryHeader, until)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !usedPrimary {
+		t.Errorf("a read with the %s header did not use the primary", readPrimaryHeader)
+	}
+
+	// A window end the server never issued does not pin the client to the primary.
+	farFuture := strconv.FormatInt(time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli(), 10)
+	for _, set := range []func(r *http.Request){
+		func(r *http.Request) { r.Header.Set(readPrimaryHeader, farFuture) },
+		func(r *http.Request) { r.AddCookie(&http.Cookie{Name: readPrimaryCookie, Value: farFuture}) },
+	} {
+		r = httptest.NewRequest("GET", "/v1/movies", nil)
+		set(r)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if usedPrimary {
+			t.Error("a read with a window ending in the year 9999 used the primary")
+		}
+	}
+}
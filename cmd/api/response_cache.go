@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"simplewebapi.moviedb/internal/cache"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCachedBody bounds the size of the responses kept by the response cache.
+const maxCachedBody = 1 << 20
+
+// responseCache holds whole responses to anonymous GET requests, keyed on the path and
+// the normalized query string. Each key holds one variant per combination of the
+// values of the request headers named by Vary.
+type responseCache struct {
+	entries *cache.LRU[string, []*cachedResponse]
+}
+
+type cachedResponse struct {
+	vary       []string // canonical names of the request headers the response varies on
+	varyValues []string
+	status     int
+	// set and added are the headers written by the handlers below the cache, see
+	// headerChanges; the middlewares above it add theirs to every response.
+	set, added http.Header
+	body       []byte
+	route      string
+	stored     time.Time
+	expires    time.Time // when max-age runs out, zero when the response sets none
+}
+
+// newResponseCache returns the cache configured by http-cache.size and http-cache.ttl,
+// or nil when it is disabled.
+func newResponseCache(cfg config) *responseCache {
+	if cfg.httpCache.size == 0 {
+		return nil
+	}
+	return &responseCache{entries: cache.NewLRU[string, []*cachedResponse](cfg.httpCache.size, cfg.httpCache.ttl)}
+}
+
+// responseCacheKey identifies a resource by its path and query. The query is
+// re-encoded with its parameters sorted, so that their order does not matter.
+func responseCacheKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+func (c *responseCache) get(r *http.Request) *cachedResponse {
+	variants, _ := c.entries.Get(responseCacheKey(r))
+	now := time.Now()
+	for _, v := range variants {
+		if slices.Equal(v.varyValues, varyValues(r, v.vary)) {
+			if !v.expires.IsZero() && !now.Before(v.expires) {
+				return nil
+			}
+			return v
+		}
+	}
+	return nil
+}
+
+func (c *responseCache) add(r *http.Request, response *cachedResponse) {
+	key := responseCacheKey(r)
+	variants, _ := c.entries.Get(key)
+	// The slice is replaced rather than changed, since readers may be ranging over it.
+	variants = slices.DeleteFunc(slices.Clone(variants), func(v *cachedResponse) bool {
+		return slices.Equal(v.varyValues, response.varyValues)
+	})
+	c.entries.Add(key, append(variants, response))
+}
+
+// movieRoute reports whether path belongs to the movie resources, the only ones whose
+// responses are public and may be held by the cache.
+func movieRoute(path string) bool {
+	return path == "/v1/movies" || strings.HasPrefix(path, "/v1/movies/")
+}
+
+func varyValues(r *http.Request, names []string) []string {
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = strings.Join(r.Header.Values(name), ",")
+	}
+	return values
+}
+
+// varyNames returns the header names listed by the Vary headers, and false when they
+// include * and the response cannot be reused at all.
+func varyNames(header http.Header) ([]string, bool) {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			switch {
+			case name == "*":
+				return nil, false
+			case name != "" && !slices.Contains(names, name):
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, true
+}
+
+// cacheLifetime reports whether a response with the given status and headers may be
+// kept and served to other anonymous clients, and for how long by its max-age or
+// s-maxage, zero meaning that it sets no limit. Responses that must be revalidated
+// every time, with a max-age of zero, are not kept.
+func cacheLifetime(status int, header http.Header) (time.Duration, bool) {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	public := false
+	maxAge, sharedMaxAge := -1, -1
+	for _, d := range strings.Split(strings.ToLower(header.Get("Cache-Control")), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		switch name {
+		case "public":
+			public = true
+		case "private", "no-store", "no-cache":
+			return 0, false
+		case "max-age", "s-maxage":
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			if name == "max-age" {
+				maxAge = seconds
+			} else {
+				sharedMaxAge = seconds
+			}
+		}
+	}
+	// s-maxage overrides max-age for shared caches, which this one is.
+	if sharedMaxAge > 0 {
+		maxAge = sharedMaxAge
+	}
+	if maxAge < 0 {
+		return 0, public
+	}
+	return time.Duration(maxAge) * time.Second, public
+}
+
+// cachingWriter passes a response through while keeping a copy of its status and body.
+// The headers are kept apart until WriteHeader, so that the cache can tell the headers
+// written by the handlers from those of the middlewares above it.
+type cachingWriter struct {
+	http.ResponseWriter
+	header     http.Header
+	before     http.Header
+	statusCode int
+	body       bytes.Buffer
+	truncated  bool
+}
+
+func newCachingWriter(w http.ResponseWriter) *cachingWriter {
+	return &cachingWriter{ResponseWriter: w, header: w.Header().Clone(), before: w.Header().Clone()}
+}
+
+func (w *cachingWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *cachingWriter) WriteHeader(code int) {
+	if w.statusCode == 0 {
+		w.statusCode = code
+		clear(w.ResponseWriter.Header())
+		for key, values := range w.header {
+			w.ResponseWriter.Header()[key] = values
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cachingWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.truncated {
+		if w.body.Len()+len(b) > maxCachedBody {
+			w.truncated = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ResponseCache serves anonymous GET requests from the response cache and stores the
+// public responses to them, taking the request headers named by Vary, such as Origin
+// and Authorization, into account. Writes to the movie routes drop the whole cache once
+// they succeed, unless they only replay a stored response, see idempotent; other writes,
+// such as logins, leave it alone. It does nothing unless http-cache.size is set.
+func (app *application) ResponseCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := app.responseCache
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		default:
+			recorder := &recordingWriter{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			if recorder.statusCode != 0 && recorder.statusCode < http.StatusBadRequest &&
+				movieRoute(r.URL.Path) && recorder.Header().Get("Idempotent-Replayed") == "" {
+				c.entries.Purge()
+			}
+			return
+		}
+		// Clients that just wrote must see their writes, see ReadYourWrites.
+		if readsOwnWrites(r, time.Now(), app.config.db.readYourWritesWindow) || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cached := c.get(r); cached != nil {
+			app.metrics.responseCacheHits.Inc()
+			app.serveCachedResponse(w, r, cached)
+			return
+		}
+		app.metrics.responseCacheMisses.Inc()
+
+		writer := newCachingWriter(w)
+		next.ServeHTTP(writer, r)
+		if writer.statusCode == 0 {
+			writer.WriteHeader(http.StatusOK)
+		}
+
+		vary, ok := varyNames(writer.header)
+		if !ok || writer.truncated {
+			return
+		}
+		lifetime, ok := cacheLifetime(writer.statusCode, writer.header)
+		if !ok {
+			return
+		}
+		set, added := headerChanges(writer.before, writer.header)
+		response := &cachedResponse{
+			vary:       vary,
+			varyValues: varyValues(r, vary),
+			status:     writer.statusCode,
+			set:        set,
+			added:      added,
+			body:       bytes.Clone(writer.body.Bytes()),
+			stored:     time.Now(),
+		}
+		if lifetime > 0 {
+			response.expires = response.stored.Add(lifetime)
+		}
+		if state := contextGetRequestState(r); state != nil {
+			response.route = state.route
+		}
+		c.add(r, response)
+	})
+}
+
+// serveCachedResponse writes cached, or 304 Not Modified when the request is
+// conditional and the client already holds it.
+func (app *application) serveCachedResponse(w http.ResponseWriter, r *http.Request, cached *cachedResponse) {
+	if state := contextGetRequestState(r); state != nil {
+		state.route = cached.route
+	}
+	for key, values := range cached.set {
+		w.Header()[key] = slices.Clone(values)
+	}
+	for key, values := range cached.added {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(cached.stored)/time.Second)))
+
+	etag := cached.set.Get("ETag")
+	lastModified, _ := http.ParseTime(cached.set.Get("Last-Modified"))
+	if (etag != "" || !lastModified.IsZero()) && notModified(r, etag, lastModified) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(cached.status)
+	w.Write(cached.body)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.httpCache.size = 10
+	app.config.httpCache.maxAge = time.Minute
+	app.responseCache = newResponseCache(app.config)
+	movie := app.seedMovie(t, "Alien", 1979, 117, "sci-fi")
+
+	get := func(header map[string]string) (int, http.Header, string) {
+		t.Helper()
+		w := app.do(t, testRequest{method: "GET", path: "/v1/movies/1", header: header})
+		return w.Code, w.Header(), w.Body.String()
+	}
+
+	code, header, body := get(nil)
+	if code != http.StatusOK || header.Get("Age") != "" {
+		t.Fatalf("first request: status %d, Age %q; want 200 from the handler", code, header.Get("Age"))
+	}
+	code, header, cached := get(nil)
+	if code != http.StatusOK || header.Get("Age") == "" || cached != body {
+		t.Errorf("second request: status %d, Age %q; want the cached response", code, header.Get("Age"))
+	}
+	if header.Get("ETag") != movieETag(movie) || len(header.Values("Vary")) != 1 || header.Get("X-Request-ID") == "" {
+		t.Errorf("cached response headers = %v", header)
+	}
+
+	// The CORS middleware makes responses vary on Origin.
+	origin := map[string]string{"Origin": "http://localhost:9000"}
+	if _, header, _ := get(origin); header.Get("Age") != "" {
+		t.Error("a request from another origin was served the response cached without one")
+	}
+	if _, header, _ := get(origin); header.Get("Access-Control-Allow-Origin") == "" || header.Get("Age") == "" {
+		t.Errorf("cached cross-origin response headers = %v", header)
+	}
+
+	code, header, _ = get(map[string]string{"If-None-Match": movieETag(movie)})
+	if code != http.StatusNotModified || header.Get("Age") == "" {
+		t.Errorf("conditional request: status %d, Age %q; want 304 from the cache", code, header.Get("Age"))
+	}
+	code, _, _ = get(map[string]string{"If-Modified-Since": movie.UpdatedAt.Add(-time.Hour).UTC().Format(http.TimeFormat)})
+	if code != http.StatusOK {
+		t.Errorf("request modified since an earlier time: status %d, want 200", code)
+	}
+
+	w := app.do(t, testRequest{method: "PATCH", path: "/v1/movies/1", body: `{"title": "Aliens"}`})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH: status %d", w.Code)
+	}
+	if _, header, body := get(nil); header.Get("Age") != "" || body == cached {
+		t.Error("a write did not drop the cached response")
+	}
+
+	// Writes that leave the movies untouched keep the cache.
+	create := testRequest{method: "POST", path: "/v1/movies", body: `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`,
+		header: map[string]string{"Idempotency-Key": "abc"}}
+	if w := app.do(t, create); w.Code != http.StatusCreated {
+		t.Fatalf("POST: status %d", w.Code)
+	}
+	get(nil)
+	writes := []testRequest{
+		create,
+		{method: "POST", path: "/v1/users", body: `{"name": "Bob", "email": "bob@example.com", "password": "pa55word"}`},
+	}
+	for _, req := range writes {
+		if w := app.do(t, req); w.Code >= http.StatusBadRequest {
+			t.Fatalf("%s %s: status %d", req.method, req.path, w.Code)
+		}
+		if _, header, _ := get(nil); header.Get("Age") == "" {
+			t.Errorf("%s %s dropped the cached response", req.method, req.path)
+		}
+	}
+}
+
+func TestResponseCacheRevalidated(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.httpCache.size = 10
+	app.responseCache = newResponseCache(app.config)
+	app.seedMovie(t, "Alien", 1979, 117, "sci-fi")
+
+	// With the default max-age of 0, clients revalidate every read, so the cache must not
+	// answer them with a copy that may be stale.
+	for range 2 {
+		w := app.do(t, testRequest{method: "GET", path: "/v1/movies/1"})
+		if w.Code != http.StatusOK || w.Header().Get("Age") != "" {
+			t.Errorf("status %d, Age %q; want 200 from the handler", w.Code, w.Header().Get("Age"))
+		}
+	}
+}
+
+func TestCacheLifetime(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		header   http.Header
+		lifetime time.Duration
+		ok       bool
+	}{
+		{"Public", 200, http.Header{"Cache-Control": {"public, max-age=60"}}, time.Minute, true},
+		{"PublicNoMaxAge", 200, http.Header{"Cache-Control": {"public"}}, 0, true},
+		{"SharedMaxAge", 200, http.Header{"Cache-Control": {"public, max-age=60, s-maxage=10"}}, 10 * time.Second, true},
+		{"QuotedMaxAge", 200, http.Header{"Cache-Control": {`PUBLIC, MAX-AGE="30"`}}, 30 * time.Second, true},
+		{"MaxAgeZero", 200, http.Header{"Cache-Control": {"public, max-age=0"}}, 0, false},
+		{"SharedMaxAgeZero", 200, http.Header{"Cache-Control": {"public, max-age=60, s-maxage=0"}}, 0, false},
+		{"InvalidMaxAge", 200, http.Header{"Cache-Control": {"public, max-age=soon"}}, 0, false},
+		{"Private", 200, http.Header{"Cache-Control": {"private, max-age=60"}}, 0, false},
+		{"NoStore", 200, http.Header{"Cache-Control": {"public, no-store"}}, 0, false},
+		{"NoCache", 200, http.Header{"Cache-Control": {"public, max-age=60, no-cache"}}, 0, false},
+		{"NotPublic", 200, http.Header{"Cache-Control": {"max-age=60"}}, time.Minute, false},
+		{"NoCacheControl", 200, http.Header{}, 0, false},
+		{"Cookie", 200, http.Header{"Cache-Control": {"public, max-age=60"}, "Set-Cookie": {"a=b"}}, 0, false},
+		{"NotFound", 404, http.Header{"Cache-Control": {"public, max-age=60"}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lifetime, ok := cacheLifetime(tt.status, tt.header)
+			if ok != tt.ok || (ok && lifetime != tt.lifetime) {
+				t.Errorf("cacheLifetime = %v, %t; want %v, %t", lifetime, ok, tt.lifetime, tt.ok)
+			}
+		})
+	}
+}
+
+func TestResponseCacheExpiry(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.httpCache.size = 10
+	app.config.httpCache.maxAge = time.Minute
+	app.responseCache = newResponseCache(app.config)
+	app.seedMovie(t, "Alien", 1979, 117, "sci-fi")
+
+	app.do(t, testRequest{method: "GET", path: "/v1/movies/1"})
+	r := httptest.NewRequest("GET", "/v1/movies/1", nil)
+	cached := app.responseCache.get(r)
+	if cached == nil || cached.expires.Sub(cached.stored) != time.Minute {
+		t.Fatalf("cached response = %+v; want one expiring after max-age", cached)
+	}
+	// Entries whose max-age ran out are not served, even within the cache TTL.
+	cached.expires = time.Now().Add(-time.Second)
+	if app.responseCache.get(r) != nil {
+		t.Error("a response past its max-age was served from the cache")
+	}
+}
@@ -2,18 +2,29 @@ package main
 
 import "net/http"
 
-func (app *application) routes() http.Handler {
+func (app *application) routes() *http.ServeMux {
 	router := http.NewServeMux()
 
 	router.HandleFunc("GET /healthcheck", app.healthcheckHandler)
-	router.HandleFunc("POST /users", app.registerUserHandler)
+	router.HandleFunc("GET /health/live", app.livenessHandler)
+	router.HandleFunc("GET /health/ready", app.readinessHandler)
+	router.HandleFunc("POST /users", app.idempotent(app.registerUserHandler))
 	router.HandleFunc("POST /users/authentication", app.authenticationHandler)
 
 	router.HandleFunc("GET /movies", app.authenticate(http.HandlerFunc(app.listMovieHandler)))
-	router.HandleFunc("POST /movies", app.createMovieHandler)
+	router.HandleFunc("POST /movies", app.idempotent(app.createMovieHandler))
+	router.HandleFunc("POST /movies/batch", app.idempotent(app.batchMovieHandler))
 	router.HandleFunc("GET /movies/{id}", app.showMovieHandler)
 	router.HandleFunc("PATCH /movies/{id}", app.updateMovieHandler)
 	router.HandleFunc("DELETE /movies/{id}", app.deleteMovieHandler)
 
 	return router
 }
+
+func (app *application) adminRoutes() http.Handler {
+	router := http.NewServeMux()
+
+	router.Handle("GET /metrics", app.metrics.registry.Handler())
+
+	return router
+}
@@ -11,53 +11,111 @@ import (
 	"time"
 )
 
-func (app *application) serve() error {
-
+// handler returns the public API: the v1 routes behind the full middleware chain.
+func (app *application) handler() http.Handler {
+	router := app.routes()
 	v1 := http.NewServeMux()
-	v1.Handle("/v1/", http.StripPrefix("/v1", app.routes()))
+	v1.Handle("/v1/", http.StripPrefix("/v1", recordRoute("/v1", router)))
 
-	allowedOrigins := []string{
-		"http://localhost:9000",
-	}
-	enableCORS := app.CORSMiddleware(allowedOrigins...)
+	api := CreateChain(
+		app.RateLimit,
+		app.ReadYourWrites,
+		app.ResponseCache,
+	)(v1)
+	// The probes bypass rate limiting, so that clients exhausting the limit cannot get
+	// the instance restarted or taken out of rotation.
+	mux := http.NewServeMux()
+	mux.Handle("/", api)
+	mux.Handle("GET /v1/health/live", v1)
+	mux.Handle("GET /v1/health/ready", v1)
 
 	middlewareChain := CreateChain(
+		app.RequestID,
+		app.Tracing,
 		app.LoggingHTTPHandler,
+		app.Metrics,
 		app.RecoverPanic,
-		enableCORS,
-		app.RateLimit,
+		app.CORS(routeMethods("/v1", router)),
 	)
+	return middlewareChain(mux)
+}
 
+func (app *application) serve() error {
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
-		Handler:      middlewareChain(v1),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		Handler:      app.handler(),
+		IdleTimeout:  app.config.server.idleTimeout,
+		ReadTimeout:  app.config.server.readTimeout,
+		WriteTimeout: app.config.server.writeTimeout,
+	}
+	// The admin listener is kept apart from the public API so that it can be firewalled off.
+	var admin *http.Server
+	if app.config.adminPort != 0 {
+		admin = &http.Server{
+			Addr:         fmt.Sprintf(":%d", app.config.adminPort),
+			Handler:      app.adminRoutes(),
+			IdleTimeout:  app.config.server.idleTimeout,
+			ReadTimeout:  app.config.server.readTimeout,
+			WriteTimeout: app.config.server.writeTimeout,
+		}
+		go func() {
+			app.logger.Info("starting admin server", "addr", admin.Addr)
+			err := admin.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("admin server error", "error", err)
+			}
+		}()
 	}
+
+	done := make(chan struct{})
+	go app.purgeIdempotencyKeys(time.Hour, done)
+	if app.replicas != nil {
+		go app.monitorReplicas(done)
+	}
+	if app.movieCache != nil && app.config.cache.notify {
+		go app.listenMovieCache(done)
+	}
+
 	shutdownError := make(chan error)
 	go func() {
 		quit := make(chan os.Signal, 1)
 
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
+		current := app.config
 		signal := <-quit
+		for signal == syscall.SIGHUP {
+			app.logger.Info("reloading configuration", "signal", signal.String())
+			current = app.reloadConfig(current)
+			signal = <-quit
+		}
 
-		app.logger.Info("Shutting down server\n", "signal: ", signal.String())
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		app.logger.Info("shutting down server", "signal", signal.String())
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.health.shutdownDelay)
+		close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.server.shutdownTimeout)
 		defer cancel()
+		if admin != nil {
+			admin.Shutdown(ctx)
+		}
 		err := server.Shutdown(ctx)
 		if err != nil {
 			shutdownError <- err
 		}
 
-		app.logger.Info("Completing background tasks...")
+		app.logger.Info("completing background tasks")
 
 		app.wg.Wait()
+
+		err = app.tracer.Shutdown(ctx)
+		if err != nil {
+			app.logger.Error("failed to flush traces", "error", err)
+		}
 		shutdownError <- nil
 	}()
 
-	app.logger.Info(fmt.Sprintf("Starting %s server on port %d:...", app.config.env, app.config.port))
+	app.logger.Info("starting server", "env", app.config.env, "addr", server.Addr)
 
 	err := server.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
@@ -68,6 +126,6 @@ func (app *application) serve() error {
 	if err != nil {
 		return err
 	}
-	app.logger.Info("Server exited")
+	app.logger.Info("server exited")
 	return nil
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/fs"
+	"simplewebapi.moviedb/internal/migrate"
+	"simplewebapi.moviedb/migrations"
+	"strings"
+)
+
+// sqliteDriver is the database/sql driver name registered for SQLite. It is empty
+// unless the binary is built with the sqlite tag, which links the driver.
+var sqliteDriver string
+
+// migrationSet returns the embedded migrations and the SQL dialect for a database driver.
+func migrationSet(driver string) (fs.FS, migrate.Dialect) {
+	if driver == "sqlite" {
+		return migrations.SQLiteFS, migrate.SQLite
+	}
+	return migrations.FS, migrate.Postgres
+}
+
+// sqlitePragmas are applied by the driver to every connection it opens, since PRAGMA
+// settings belong to a connection.
+var sqlitePragmas = []string{"foreign_keys(1)", "busy_timeout(5000)"}
+
+// sqliteDSN adds sqlitePragmas to the query of dsn.
+func sqliteDSN(dsn string) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	for _, pragma := range sqlitePragmas {
+		dsn += sep + "_pragma=" + pragma
+		sep = "&"
+	}
+	return dsn
+}
@@ -0,0 +1,37 @@
+//go:build sqlite
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenSQLitePragmas(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.db.driver = "sqlite"
+	cfg.db.dsn = "file:" + filepath.Join(t.TempDir(), "movies.db") + "?_txlock=immediate"
+	db, err := openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Each round replaces the connection, which must get the pragmas again.
+	for range 2 {
+		var foreignKeys, busyTimeout int
+		err = db.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if foreignKeys != 1 || busyTimeout != 5000 {
+			t.Errorf("foreign_keys = %d, busy_timeout = %d; want 1 and 5000", foreignKeys, busyTimeout)
+		}
+		db.SetMaxIdleConns(0)
+		db.SetMaxIdleConns(1)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/trace"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata instead of comparing against them")
+
+// newTestApplication returns an application backed by in-memory repositories,
+// with rate limiting disabled and logs discarded.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	cfg := defaultConfig()
+	cfg.db.driver = "memory"
+	cfg.limiter.enabled = false
+	cfg.users.bcryptCost = bcrypt.MinCost
+
+	var logLevel slog.LevelVar
+	app := &application{
+		config:   cfg,
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		repos:    data.NewMemoryRepo(),
+		metrics:  newAppMetrics(nil, nil),
+		tracer:   trace.NewTracer(nil, nil),
+		logLevel: &logLevel,
+	}
+	app.applyConfig(cfg)
+	return app
+}
+
+// testRequest describes a request sent through app.handler().
+type testRequest struct {
+	method string
+	path   string
+	body   string
+	header map[string]string
+}
+
+func (app *application) do(t *testing.T, req testRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+	for key, value := range req.header {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	app.handler().ServeHTTP(w, r)
+	return w
+}
+
+func (app *application) seedMovie(t *testing.T, title string, year int32, runtime data.Runtime, genres ...string) *data.Movie {
+	t.Helper()
+	movie := &data.Movie{Title: title, Year: year, Runtime: runtime, Genres: genres}
+	err := app.repos.Movies.Insert(context.Background(), movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return movie
+}
+
+// seedUser creates a user with the password "pa55word" and returns an authentication token for it.
+func (app *application) seedUser(t *testing.T, name, email string) string {
+	t.Helper()
+	user := &data.User{Name: name, Email: email}
+	err := user.Password.Set("pa55word", app.config.users.bcryptCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.repos.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := app.repos.Tokens.New(context.Background(), user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.Plaintext
+}
+
+// goldenHeaders are the response headers recorded in golden files. Others, such as
+// X-Request-ID, change on every run or say nothing about the handler.
+var goldenHeaders = []string{"Allow", "Cache-Control", "Content-Type", "ETag", "Last-Modified", "Location", "Idempotent-Replayed", "Vary"}
+
+// The values below change between runs and are replaced before comparing.
+var goldenReplacements = []struct {
+	rx   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`"\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})"`), `"<timestamp>"`},
+	{regexp.MustCompile(`"token": "[A-Z2-7]{26}"`), `"token": "<token>"`},
+	{regexp.MustCompile(`"latency": "[^"]*"`), `"latency": "<duration>"`},
+	{regexp.MustCompile(`"request_id": "[0-9a-f]{32}"`), `"request_id": "<request-id>"`},
+	{regexp.MustCompile(`(?m)^(Last-Modified): .*$`), `$1: <http-date>`},
+}
+
+func renderResponse(w *httptest.ResponseRecorder) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "HTTP %d\n", w.Code)
+	keys := append([]string(nil), goldenHeaders...)
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range w.Header().Values(key) {
+			fmt.Fprintf(&b, "%s: %s\n", key, value)
+		}
+	}
+	b.WriteString("\n")
+	b.Write(w.Body.Bytes())
+
+	rendered := b.Bytes()
+	for _, r := range goldenReplacements {
+		rendered = r.rx.ReplaceAll(rendered, []byte(r.repl))
+	}
+	return rendered
+}
+
+// checkGolden compares the response with testdata/<test name>.golden, or rewrites
+// that file when the tests run with -update.
+func checkGolden(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	got := renderResponse(w)
+	path := filepath.Join("testdata", filepath.FromSlash(t.Name())+".golden")
+
+	if *update {
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err == nil {
+			err = os.WriteFile(path, got, 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("golden file %s does not exist, run the tests with -update to create it", path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("response does not match %s\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
+
+// failingMovies is a MoviesRepoInterface whose every call fails, to exercise the 500 paths.
+type failingMovies struct{}
+
+var errDatabaseDown = errors.New("database is down")
+
+func (failingMovies) Insert(context.Context, *data.Movie) error { return errDatabaseDown }
+func (failingMovies) Get(context.Context, int64) (*data.Movie, error) {
+	return nil, errDatabaseDown
+}
+func (failingMovies) GetAll(context.Context, string, []string, data.Filter) ([]*data.Movie, data.Metadata, error) {
+	return nil, data.Metadata{}, errDatabaseDown
+}
+func (failingMovies) Update(context.Context, *data.Movie) error { return errDatabaseDown }
+func (failingMovies) Delete(context.Context, int64) error       { return errDatabaseDown }
+func (failingMovies) DeleteVersion(context.Context, int64, int32) error {
+	return errDatabaseDown
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"simplewebapi.moviedb/internal/trace"
+)
+
+// Tracing starts a server span for every request, continuing the trace of an
+// incoming W3C traceparent header when there is one.
+func (app *application) Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.tracer.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if sc, ok := trace.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = trace.ContextWithRemoteParent(ctx, sc)
+		}
+		ctx, span := app.tracer.Start(ctx, r.Method, trace.KindServer)
+		defer span.Finish()
+
+		writer := &WrappedWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(writer, r)
+
+		span.SetAttr("http.request.method", r.Method)
+		span.SetAttr("url.path", r.URL.Path)
+		span.SetAttr("http.response.status_code", writer.statusCode)
+		span.SetAttr("request.id", contextGetRequestID(r))
+		if state := contextGetRequestState(r); state != nil && state.route != "" {
+			span.SetName(r.Method + " " + state.route)
+			span.SetAttr("http.route", state.route)
+		}
+		if writer.statusCode >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("%d %s", writer.statusCode, http.StatusText(writer.statusCode)))
+		}
+	})
+}
+
+func newTraceExporter(cfg config) (trace.Exporter, error) {
+	switch cfg.trace.exporter {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return trace.NewWriterExporter(nopCloser{os.Stdout}), nil
+	case "file":
+		f, err := os.OpenFile(cfg.trace.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return trace.NewWriterExporter(f), nil
+	case "otlp":
+		return trace.NewOTLPExporter(cfg.trace.otlpEndpoint, "moviedb"), nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", cfg.trace.exporter)
+}
+
+// nopCloser keeps the exporter from closing stdout on shutdown.
+type nopCloser struct {
+	io.Writer
+}
+
+// dbSystem returns the db.system span attribute naming the database of a driver.
+func dbSystem(driver string) string {
+	switch driver {
+	case "postgres":
+		return "postgresql"
+	case "sqlite":
+		return "sqlite"
+	}
+	return driver
+}
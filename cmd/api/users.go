@@ -24,21 +24,30 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	var user data.User
-	userMapper(input, &user)
+	userMapper(input, &user, app.config.users.bcryptCost)
 
 	v := validator.New()
 	if data.ValidateUser(v, &user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.repos.Users.Insert(&user)
+	// The user and its activation token are created together, so that a failure
+	// never leaves behind a user who cannot be activated.
+	err = app.repos.WithTx(r.Context(), func(tx data.Repo) error {
+		err := tx.Users.Insert(r.Context(), &user)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Tokens.New(r.Context(), user.ID, app.config.tokens.activationTTL, data.ScopeActivation)
+		return err
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("Email", "a user with this Email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.dbErrorResponse(w, r, err)
 		}
 		return
 	}
@@ -50,11 +59,11 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-func userMapper(input UserInput, u *data.User) {
+func userMapper(input UserInput, u *data.User, bcryptCost int) {
 	if u == nil {
 		u = &data.User{}
 	}
 	u.Name = input.Name
 	u.Email = input.Email
-	u.Password.Set(input.Password)
+	u.Password.Set(input.Password, bcryptCost)
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"simplewebapi.moviedb/internal/data"
+	"testing"
+	"time"
+)
+
+func TestUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     testRequest
+		problem bool // set error-format=problem
+	}{
+		{name: "RegisterUser", req: testRequest{method: "POST", path: "/v1/users",
+			body: `{"name": "Bob", "email": "bob@example.com", "password": "pa55word"}`}},
+		{name: "RegisterUserDuplicateEmail", req: testRequest{method: "POST", path: "/v1/users",
+			body: `{"name": "Alice", "email": "alice@example.com", "password": "pa55word"}`}},
+		{name: "RegisterUserInvalid", req: testRequest{method: "POST", path: "/v1/users",
+			body: `{"name": "", "email": "not-an-email", "password": "short"}`}},
+		{name: "RegisterUserBadJSON", req: testRequest{method: "POST", path: "/v1/users", body: `{"name": "Bob", "admin": true}`}},
+		{name: "RegisterUserIdempotent", req: testRequest{method: "POST", path: "/v1/users",
+			body:   `{"name": "Bob", "email": "bob@example.com", "password": "pa55word"}`,
+			header: map[string]string{"Idempotency-Key": "register-bob"}}},
+		{name: "RegisterUserInvalidProblem", req: testRequest{method: "POST", path: "/v1/users",
+			body:   `{"name": "", "email": "not-an-email", "password": "short"}`,
+			header: map[string]string{"Accept": "application/problem+json"}}},
+		{name: "RegisterUserDuplicateEmailProblem", problem: true, req: testRequest{method: "POST", path: "/v1/users",
+			body: `{"name": "Alice", "email": "alice@example.com", "password": "pa55word"}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.seedUser(t, "Alice", "alice@example.com")
+			if tt.problem {
+				app.config.errorFormat = errorFormatProblem
+			}
+			checkGolden(t, app.do(t, tt.req))
+		})
+	}
+}
+
+// failingTokens is a TokensRepoInterface whose New fails.
+type failingTokens struct {
+	data.TokensRepoInterface
+}
+
+func (failingTokens) New(context.Context, int64, time.Duration, string) (*data.Token, error) {
+	return nil, errDatabaseDown
+}
+
+func TestRegisterUserRollback(t *testing.T) {
+	app := newTestApplication(t)
+	app.repos = app.repos.Wrap(func(r data.Repo) data.Repo {
+		r.Tokens = failingTokens{r.Tokens}
+		return r
+	})
+
+	w := app.do(t, testRequest{method: "POST", path: "/v1/users",
+		body: `{"name": "Bob", "email": "bob@example.com", "password": "pa55word"}`})
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d; want 500 when the activation token cannot be created", w.Code)
+	}
+	_, err := app.repos.Users.GetByEmail(context.Background(), "bob@example.com")
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("GetByEmail after the failed registration: got %v; want ErrRecordNotFound", err)
+	}
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Now()
+	c := NewLRU[string, int](2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a is missing")
+	}
+	// b is now the least recently used entry.
+	c.Add("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("got a = %d, %t; want 1, true", v, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("c"); ok {
+		t.Error("c did not expire")
+	}
+	if c.Len() != 1 {
+		t.Errorf("got %d entries; want 1", c.Len())
+	}
+
+	c.Purge()
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Error("Purge left entries behind")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	var (
+		g       Group[string, int]
+		loads   atomic.Int32
+		release = make(chan struct{})
+		started = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	load := func() (int, error) {
+		if loads.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return 42, nil
+	}
+
+	results := make([]int, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _, _ = g.Do("key", load)
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _, _ = g.Do("key", load)
+		}()
+	}
+	// Give the waiters time to join the load in flight.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := loads.Load(); n != 1 {
+		t.Errorf("load ran %d times; want 1", n)
+	}
+	for i, v := range results {
+		if v != 42 {
+			t.Errorf("caller %d got %d; want 42", i, v)
+		}
+	}
+}
+
+func TestGroupPanic(t *testing.T) {
+	var (
+		g       Group[string, int]
+		release = make(chan struct{})
+		started = make(chan struct{})
+		waiter  = make(chan error)
+	)
+	go func() {
+		defer func() { recover() }()
+		g.Do("key", func() (int, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+	go func() {
+		_, err, _ := g.Do("key", func() (int, error) { return 42, nil })
+		waiter <- err
+	}()
+	// Give the waiter time to join the load in flight.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	if err := <-waiter; !errors.Is(err, ErrLoadPanicked) {
+		t.Errorf("waiter got %v; want ErrLoadPanicked", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("the caller running the load did not panic")
+		}
+	}()
+	g.Do("key", func() (int, error) { panic("boom") })
+}
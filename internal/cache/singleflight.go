@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLoadPanicked is returned to the callers waiting for a load that panicked or
+// exited its goroutine; the caller running it panics as usual.
+var ErrLoadPanicked = errors.New("cache: load panicked")
+
+// Group runs at most one load per key at a time; callers asking for a key that is
+// already being loaded wait for that load and share its result.
+type Group[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*call[V]
+}
+
+type call[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// Do runs load for key unless a load of key is in flight, in which case it waits for
+// it. shared reports whether the result came from another caller's load.
+func (g *Group[K, V]) Do(key K, load func() (V, error)) (value V, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*call[V])
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.value, c.err, true
+	}
+	c := &call[V]{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			var zero V
+			c.value, c.err = zero, ErrLoadPanicked
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.value, c.err = load()
+	returned = true
+	return c.value, c.err, false
+}
@@ -0,0 +1,274 @@
+package configfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Values maps dotted keys such as "db.max-open-conns" to raw string values.
+// Lists are stored comma separated.
+type Values map[string]string
+
+// NormalizeKey lower-cases key and uses dashes as word separators, so that
+// "maxOpenConns"-style keys are not required but "max_open_conns" works too.
+func NormalizeKey(key string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(key), "_", "-"))
+}
+
+// Parse reads a configuration file, choosing the format by its extension.
+func Parse(name string, r io.Reader) (Values, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return ParseYAML(r)
+	case ".toml":
+		return ParseTOML(r)
+	}
+	return nil, fmt.Errorf("unsupported config file format %q, use .yaml, .yml or .toml", filepath.Ext(name))
+}
+
+// ParseYAML understands the subset of YAML needed for configuration files: nested
+// mappings, scalars, flow lists ("[a, b]") and block lists ("- a"). Flow mappings,
+// anchors and multi-line scalars are not supported.
+func ParseYAML(r io.Reader) (Values, error) {
+	type level struct {
+		indent int
+		prefix string
+		// child is the indentation of the keys of the mapping, -1 until the first one.
+		child int
+	}
+	values := make(Values)
+	stack := []level{{indent: -1, child: -1}}
+	var listKey string
+	var listIndent int
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := stripComment(scanner.Text(), true)
+		if strings.TrimSpace(raw) == "" || strings.TrimSpace(raw) == "---" {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		if strings.HasPrefix(strings.TrimLeft(raw, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", lineNo)
+		}
+		line := strings.TrimSpace(raw)
+
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			if listKey == "" || indent < listIndent {
+				return nil, fmt.Errorf("line %d: list item without a key", lineNo)
+			}
+			item, err := unquote(strings.TrimSpace(strings.TrimPrefix(line, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if values[listKey] == "" {
+				values[listKey] = item
+			} else {
+				values[listKey] += "," + item
+			}
+			continue
+		}
+
+		for indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := &stack[len(stack)-1]
+		if parent.child == -1 {
+			parent.child = indent
+		} else if indent != parent.child {
+			return nil, fmt.Errorf("line %d: inconsistent indentation", lineNo)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineNo)
+		}
+		key = NormalizeKey(key)
+		if parent.prefix != "" {
+			if values[parent.prefix] != "" {
+				return nil, fmt.Errorf("line %d: %q is a list and cannot hold keys", lineNo, parent.prefix)
+			}
+			key = parent.prefix + "." + key
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNo, key)
+		}
+		value = strings.TrimSpace(value)
+		listKey = ""
+
+		switch {
+		case value == "":
+			// Either a nested mapping or a block list follows.
+			stack = append(stack, level{indent: indent, prefix: key, child: -1})
+			listKey, listIndent = key, indent
+			values[key] = ""
+		case strings.HasPrefix(value, "["):
+			list, err := parseList(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			values[key] = list
+		case strings.HasPrefix(value, "{"):
+			return nil, fmt.Errorf("line %d: flow mappings are not supported", lineNo)
+		default:
+			v, err := unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			values[key] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// Keys that only introduced a nested mapping carry no value of their own.
+	for key, value := range values {
+		if value == "" && hasChildren(values, key) {
+			delete(values, key)
+		}
+	}
+	return values, nil
+}
+
+// ParseTOML understands "[table]" headers, dotted keys and "key = value" pairs
+// with string, number, boolean and single-line array values. Inline tables, arrays of
+// tables, quoted keys and multi-line strings are not supported.
+func ParseTOML(r io.Reader) (Values, error) {
+	values := make(Values)
+	table := ""
+	tables := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripComment(scanner.Text(), false))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header", lineNo)
+			}
+			table = NormalizeKey(strings.Trim(line, "[]"))
+			if tables[table] {
+				return nil, fmt.Errorf("line %d: duplicate table %q", lineNo, table)
+			}
+			tables[table] = true
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key = value\"", lineNo)
+		}
+		if strings.ContainsAny(key, `"'`) {
+			return nil, fmt.Errorf("line %d: quoted keys are not supported", lineNo)
+		}
+		key = NormalizeKey(key)
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNo, key)
+		}
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch {
+		case strings.HasPrefix(value, "["):
+			values[key], err = parseList(value)
+		case strings.HasPrefix(value, "{"):
+			err = errors.New("inline tables are not supported")
+		default:
+			values[key], err = unquote(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func hasChildren(values Values, key string) bool {
+	for k := range values {
+		if strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// stripComment removes a trailing "#" comment that is not inside a quoted string.
+// Quotes only open a string at the start of a value, so that the apostrophe of a
+// plain value such as O'Brien does not hide a comment. YAML only starts a comment
+// after whitespace, which afterSpace asks for.
+func stripComment(line string, afterSpace bool) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+			// A quote doubled inside single quotes stands for itself.
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t:=[,", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (!afterSpace || i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		inner := s[1 : len(s)-1]
+		if strings.Contains(strings.ReplaceAll(inner, "''", ""), "'") {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return strings.ReplaceAll(inner, "''", "'"), nil
+	}
+	return s, nil
+}
+
+func parseList(s string) (string, error) {
+	if !strings.HasSuffix(s, "]") {
+		return "", fmt.Errorf("unterminated list %s", s)
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	if inner == "" {
+		return "", nil
+	}
+	var items []string
+	for _, item := range strings.Split(inner, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		v, err := unquote(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, v)
+	}
+	return strings.Join(items, ","), nil
+}
@@ -0,0 +1,168 @@
+package configfile
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Values
+	}{
+		{"scalars", "port: 4000\nenv: production\n", Values{"port": "4000", "env": "production"}},
+		{"nesting", "db:\n  dsn: postgres://localhost/movies\n  pool:\n    max_open_conns: 25\nport: 4000\n",
+			Values{"db.dsn": "postgres://localhost/movies", "db.pool.max-open-conns": "25", "port": "4000"}},
+		{"quoting", "a: \"two words\"\nb: 'single # quoted'\nc: \"escaped \\\" quote\"\nd: \"#\"\n",
+			Values{"a": "two words", "b": "single # quoted", "c": `escaped " quote`, "d": "#"}},
+		{"comments", "# leading comment\n---\nport: 4000 # trailing comment\n\n   # indented comment\nenv: dev\n",
+			Values{"port": "4000", "env": "dev"}},
+		{"flow list", "cors:\n  trusted_origins: [\"http://a.example\", http://b.example]\n",
+			Values{"cors.trusted-origins": "http://a.example,http://b.example"}},
+		{"block list", "cors:\n  trusted_origins:\n    - http://a.example\n    - 'http://b.example'\nport: 1\n",
+			Values{"cors.trusted-origins": "http://a.example,http://b.example", "port": "1"}},
+		{"empty value", "db:\n  dsn:\n", Values{"db.dsn": ""}},
+		{"empty list", "origins: []\n", Values{"origins": ""}},
+		{"normalized keys", "DB:\n  Max_Open_Conns: 5\n", Values{"db.max-open-conns": "5"}},
+		{"deep nesting", "a:\n  b:\n    c: 1\n  d: 2\ne: 3\n", Values{"a.b.c": "1", "a.d": "2", "e": "3"}},
+		{"four space indentation", "db:\n    dsn: x\n    pool:\n        size: 2\nport: 1\n",
+			Values{"db.dsn": "x", "db.pool.size": "2", "port": "1"}},
+		{"indented document", "  db:\n    dsn: x\n  port: 1\n", Values{"db.dsn": "x", "port": "1"}},
+		{"block list at key indentation", "origins:\n- a\n- b\nport: 1\n", Values{"origins": "a,b", "port": "1"}},
+		{"windows line endings", "db:\r\n  dsn: x\r\nport: 1 # c\r\n", Values{"db.dsn": "x", "port": "1"}},
+		{"colons in values", "dsn: postgres://u:p@h/db?sslmode=disable\nq: \"a: b\"\n",
+			Values{"dsn": "postgres://u:p@h/db?sslmode=disable", "q": "a: b"}},
+		{"hash without space", "url: http://x/#frag # comment\n", Values{"url": "http://x/#frag"}},
+		{"apostrophe in plain value", "name: O'Brien # comment\n", Values{"name": "O'Brien"}},
+		{"escaped single quote", "name: 'it''s # here' # comment\n", Values{"name": "it's # here"}},
+		{"double quoted escapes", "a: \"tab\\tend\"\nb: \"it's\" # c\n", Values{"a": "tab\tend", "b": "it's"}},
+		{"quoted list items", "origins: [\"http://a/#x\", 'b # c'] # comment\n", Values{"origins": "http://a/#x,b # c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseYAML(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"tab indentation", "db:\n\tdsn: x\n", "line 2: tabs are not allowed"},
+		{"missing colon", "port 4000\n", "line 1: expected \"key: value\""},
+		{"list without key", "- a\n", "line 1: list item without a key"},
+		{"unterminated list", "origins: [a, b\n", "line 1: unterminated list"},
+		{"unterminated string", "name: 'abc\n", "line 1: unterminated string"},
+		{"bad escape", "name: \"\\q\"\n", "line 1:"},
+		{"inconsistent indentation", "db:\n    dsn: x\n  port: 1\n", "line 3: inconsistent indentation"},
+		{"indentation under a scalar", "a: x\n  b: y\n", "line 2: inconsistent indentation"},
+		{"keys under a list", "a:\n  - x\n  b: 1\n", "line 3: \"a\" is a list"},
+		{"list under keys", "a:\n  b: 1\n  - x\n", "line 3: list item without a key"},
+		{"duplicate key", "port: 1\nport: 2\n", "line 2: duplicate key \"port\""},
+		{"duplicate mapping", "db:\n  dsn: x\ndb:\n  port: 1\n", "line 3: duplicate key \"db\""},
+		{"flow mapping", "db: {dsn: x}\n", "line 1: flow mappings are not supported"},
+		{"stray single quote", "name: 'it's'\n", "line 1: invalid string"},
+		{"text after quotes", "name: \"a\" b\n", "line 1:"},
+		{"comma in list item", "origins: [\"a,b\"]\n", "line 1:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseYAML(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v; want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Values
+	}{
+		{"scalars", "port = 4000\nenv = \"production\"\nauto_migrate = true\n",
+			Values{"port": "4000", "env": "production", "auto-migrate": "true"}},
+		{"tables", "port = 1\n[db]\ndsn = 'postgres://localhost/movies'\n[limiter]\nrps = 2.5\n",
+			Values{"port": "1", "db.dsn": "postgres://localhost/movies", "limiter.rps": "2.5"}},
+		{"dotted keys", "db.max_open_conns = 25\n", Values{"db.max-open-conns": "25"}},
+		{"comments", "# comment\nname = \"a # b\" # trailing\n", Values{"name": "a # b"}},
+		{"arrays", "[cors]\ntrusted_origins = [\"http://a.example\", 'http://b.example',]\n",
+			Values{"cors.trusted-origins": "http://a.example,http://b.example"}},
+		{"nested tables", "[db.pool]\nmax_open = 5\n[db]\ndsn = \"x\"\n", Values{"db.pool.max-open": "5", "db.dsn": "x"}},
+		{"header spacing", "[ DB ] # comment\ndsn = 'x'\n", Values{"db.dsn": "x"}},
+		{"dotted key in table", "[db]\npool.size = 2\n", Values{"db.pool.size": "2"}},
+		{"no spaces", "port=4000#comment\n", Values{"port": "4000"}},
+		{"literal string", "a = 'x # \"y\"' # comment\n", Values{"a": `x # "y"`}},
+		{"basic string escapes", "a = \"tab\\tend \\\" # not a comment\"\n", Values{"a": "tab\tend \" # not a comment"}},
+		{"hash in array", "a = [\"x#1\", 'y # 2'] # comment\n", Values{"a": "x#1,y # 2"}},
+		{"windows line endings", "[db]\r\ndsn = \"x\"\r\n", Values{"db.dsn": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTOML(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"unclosed table", "[db\n", "line 1: invalid table header"},
+		{"array of tables", "[[db]]\n", "line 1: invalid table header"},
+		{"missing equals", "port 4000\n", "line 1: expected \"key = value\""},
+		{"unterminated array", "a = 1\nb = [1, 2\n", "line 2: unterminated list"},
+		{"unterminated string", "name = \"abc\n", "line 1:"},
+		{"duplicate key", "port = 1\nport = 2\n", "line 2: duplicate key \"port\""},
+		{"duplicate table", "[db]\ndsn = 'x'\n[limiter]\n[db]\n", "line 4: duplicate table \"db\""},
+		{"key of table and dotted key", "db.dsn = 'x'\n[db]\ndsn = 'y'\n", "line 3: duplicate key \"db.dsn\""},
+		{"inline table", "db = { dsn = \"x\" }\n", "line 1: inline tables are not supported"},
+		{"quoted key", "\"db.dsn\" = 'x'\n", "line 1: quoted keys are not supported"},
+		{"multi-line array", "origins = [\n  \"a\",\n]\n", "line 1: unterminated list"},
+		{"multi-line string", "a = \"\"\"x\"\"\"\n", "line 1:"},
+		{"stray single quote", "a = 'it's'\n", "line 1: invalid string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTOML(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v; want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	got, err := Parse("api.YML", strings.NewReader("port: 1\n"))
+	if err != nil || got["port"] != "1" {
+		t.Errorf("Parse of a .YML file = %v, %v", got, err)
+	}
+	got, err = Parse("api.toml", strings.NewReader("port = 1\n"))
+	if err != nil || got["port"] != "1" {
+		t.Errorf("Parse of a .toml file = %v, %v", got, err)
+	}
+	_, err = Parse("api.json", strings.NewReader("{}"))
+	if err == nil || !strings.Contains(err.Error(), "unsupported config file format") {
+		t.Errorf("Parse of a .json file: got %v", err)
+	}
+}
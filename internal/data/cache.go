@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"simplewebapi.moviedb/internal/cache"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MovieCache keeps recently read movies and pages of GetAll in memory. Writes made
+// through a Repo returned by Cached drop the entries they may have changed, and
+// concurrent misses on the same entry share a single query.
+type MovieCache struct {
+	movies *cache.LRU[int64, *Movie]
+	lists  *cache.LRU[listKey, movieList]
+
+	loadMovie cache.Group[generationKey[int64], *Movie]
+	loadList  cache.Group[generationKey[listKey], movieList]
+
+	// generation changes on every invalidation. Results of queries started before
+	// it are not cached, and concurrent misses only share a query of their generation.
+	mu         sync.Mutex
+	generation uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	// instance tells the invalidations announced by this process apart from those of
+	// other instances, see PublishTo and Listen.
+	instance string
+	publish  func(id int64)
+}
+
+// listKey identifies a page of GetAll by its normalized arguments: the lexemes of the
+// title and the set of genres, which match the same movies whatever their order.
+type listKey struct {
+	title    string
+	genres   string
+	sort     string
+	page     int
+	pageSize int
+}
+
+func newListKey(title string, genres []string, filter Filter) listKey {
+	genres = slices.Clone(genres)
+	slices.Sort(genres)
+	return listKey{
+		title:    strings.Join(lexemes(title), " "),
+		genres:   strings.Join(slices.Compact(genres), "\x00"),
+		sort:     filter.Sort,
+		page:     filter.Page,
+		pageSize: filter.PageSize,
+	}
+}
+
+type movieList struct {
+	movies   []*Movie
+	metadata Metadata
+}
+
+type generationKey[K comparable] struct {
+	generation uint64
+	key        K
+}
+
+// CacheStats describes the use of a MovieCache.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Movies int // entries of Movies.Get
+	Lists  int // entries of Movies.GetAll
+}
+
+// NewMovieCache returns an empty cache holding up to size movies and size pages of
+// GetAll, each for at most ttl.
+func NewMovieCache(size int, ttl time.Duration) *MovieCache {
+	instance := make([]byte, 8)
+	_, _ = rand.Read(instance)
+	return &MovieCache{
+		movies:   cache.NewLRU[int64, *Movie](size, ttl),
+		lists:    cache.NewLRU[listKey, movieList](size, ttl),
+		instance: hex.EncodeToString(instance),
+	}
+}
+
+// Stats returns the number of hits, misses and entries so far.
+func (c *MovieCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Movies: c.movies.Len(),
+		Lists:  c.lists.Len(),
+	}
+}
+
+// Invalidate drops the movie with the given ID, unless it is zero, and every page of
+// GetAll, which may list it.
+func (c *MovieCache) Invalidate(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if id != 0 {
+		c.movies.Remove(id)
+	}
+	c.lists.Purge()
+}
+
+// Purge drops every entry.
+func (c *MovieCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.movies.Purge()
+	c.lists.Purge()
+}
+
+// invalidate drops the entries of the movie with the given ID and announces it to
+// the other instances.
+func (c *MovieCache) invalidate(id int64) {
+	c.Invalidate(id)
+	if c.publish != nil {
+		c.publish(id)
+	}
+}
+
+func (c *MovieCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// store runs add unless the cache was invalidated since generation.
+func (c *MovieCache) store(generation uint64, add func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		add()
+	}
+}
+
+// load runs query once for all the concurrent misses on key. A caller whose own
+// context is still alive runs query again if the shared one was canceled by another
+// caller's context.
+func load[K comparable, V any](ctx context.Context, c *MovieCache, group *cache.Group[generationKey[K], V], key K,
+	query func(ctx context.Context) (V, error), add func(V)) (V, error) {
+	generation := c.currentGeneration()
+	value, err, shared := group.Do(generationKey[K]{generation, key}, func() (V, error) {
+		value, err := query(ctx)
+		if err == nil {
+			c.store(generation, func() { add(value) })
+		}
+		return value, err
+	})
+	if shared && errors.Is(err, ErrCanceled) && ctx.Err() == nil {
+		return query(ctx)
+	}
+	return value, err
+}
+
+// Cached returns a copy of r whose Movies.Get and Movies.GetAll are served from c
+// where possible. Reads inside WithTx and reads routed to the primary with UsePrimary
+// skip the cache; writes invalidate it, those inside WithTx once they have committed.
+// Misses are read from the primary, since a lagging replica could fill the cache with
+// a movie older than the invalidation that emptied it. It must be applied after
+// WithReplicas and before Traced.
+func (r Repo) Cached(c *MovieCache) Repo {
+	if c == nil {
+		return r
+	}
+	r.Movies = cachedMovies{next: r.Movies, cache: c, afterCommit: r.afterCommit}
+	decorate := r.decorate
+	r.decorate = func(tx Repo) Repo {
+		if decorate != nil {
+			tx = decorate(tx)
+		}
+		tx.Movies = cachedMovies{next: tx.Movies, cache: c, inTx: true, afterCommit: tx.afterCommit}
+		return tx
+	}
+	return r
+}
+
+type cachedMovies struct {
+	next        MoviesRepoInterface
+	cache       *MovieCache
+	inTx        bool
+	afterCommit func(func())
+}
+
+func (m cachedMovies) bypass(ctx context.Context) bool {
+	return m.inTx || UsesPrimary(ctx)
+}
+
+func (m cachedMovies) Insert(ctx context.Context, movie *Movie) error {
+	err := m.next.Insert(ctx, movie)
+	m.afterCommit(func() { m.cache.invalidate(0) })
+	return err
+}
+
+func (m cachedMovies) Get(ctx context.Context, id int64) (*Movie, error) {
+	if m.bypass(ctx) {
+		return m.next.Get(ctx, id)
+	}
+	if movie, ok := m.cache.movies.Get(id); ok {
+		m.cache.hits.Add(1)
+		return copyMovie(*movie), nil
+	}
+	m.cache.misses.Add(1)
+
+	movie, err := load(ctx, m.cache, &m.cache.loadMovie, id, func(ctx context.Context) (*Movie, error) {
+		return m.next.Get(UsePrimary(ctx), id)
+	}, func(movie *Movie) {
+		m.cache.movies.Add(id, copyMovie(*movie))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyMovie(*movie), nil
+}
+
+func (m cachedMovies) GetAll(ctx context.Context, title string, genres []string, filter Filter) ([]*Movie, Metadata, error) {
+	if m.bypass(ctx) {
+		return m.next.GetAll(ctx, title, genres, filter)
+	}
+	key := newListKey(title, genres, filter)
+	if list, ok := m.cache.lists.Get(key); ok {
+		m.cache.hits.Add(1)
+		return copyMovies(list.movies), list.metadata, nil
+	}
+	m.cache.misses.Add(1)
+
+	list, err := load(ctx, m.cache, &m.cache.loadList, key, func(ctx context.Context) (movieList, error) {
+		movies, metadata, err := m.next.GetAll(UsePrimary(ctx), title, genres, filter)
+		return movieList{movies, metadata}, err
+	}, func(list movieList) {
+		m.cache.lists.Add(key, movieList{copyMovies(list.movies), list.metadata})
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return copyMovies(list.movies), list.metadata, nil
+}
+
+// Update, Delete and DeleteVersion invalidate the movie even when they fail, since a conflict or a
+// missing row means that the cached copy is out of date.
+func (m cachedMovies) Update(ctx context.Context, movie *Movie) error {
+	err := m.next.Update(ctx, movie)
+	m.afterCommit(func() { m.cache.invalidate(movie.ID) })
+	return err
+}
+
+func (m cachedMovies) Delete(ctx context.Context, id int64) error {
+	err := m.next.Delete(ctx, id)
+	m.afterCommit(func() { m.cache.invalidate(id) })
+	return err
+}
+
+func (m cachedMovies) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	err := m.next.DeleteVersion(ctx, id, version)
+	m.afterCommit(func() { m.cache.invalidate(id) })
+	return err
+}
+
+func copyMovies(movies []*Movie) []*Movie {
+	if movies == nil {
+		return nil
+	}
+	copies := make([]*Movie, len(movies))
+	for i, movie := range movies {
+		copies[i] = copyMovie(*movie)
+	}
+	return copies
+}
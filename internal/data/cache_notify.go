@@ -0,0 +1,59 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MovieCacheChannel is the PostgreSQL notification channel on which instances announce
+// the movies they changed, so that every instance can drop them from its MovieCache.
+const MovieCacheChannel = "movie_cache"
+
+// PublishTo makes c announce its invalidations on MovieCacheChannel through db, the
+// primary. report is called with the error of every announcement that failed.
+func (c *MovieCache) PublishTo(db *sql.DB, timeout time.Duration, report func(error)) {
+	c.publish = func(id int64) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		payload := c.instance + ":" + strconv.FormatInt(id, 10)
+		_, err := db.ExecContext(ctx, "SELECT pg_notify($1, $2)", MovieCacheChannel, payload)
+		if err != nil {
+			report(err)
+		}
+	}
+}
+
+// Listen applies the invalidations announced by other instances, read from the Notify
+// channel of a pq.Listener listening on MovieCacheChannel, until done is closed or the
+// listener is closed. The listener sends nil after it reconnected, when notifications
+// may have been lost, so the whole cache is dropped then.
+func (c *MovieCache) Listen(notifications <-chan *pq.Notification, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if n == nil {
+				c.Purge()
+				continue
+			}
+			instance, id, found := strings.Cut(n.Extra, ":")
+			if instance == c.instance {
+				continue
+			}
+			movieID, err := strconv.ParseInt(id, 10, 64)
+			if !found || err != nil {
+				c.Purge()
+				continue
+			}
+			c.Invalidate(movieID)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/data/datatest"
+	"testing"
+	"time"
+)
+
+func TestCachedRepo(t *testing.T) {
+	datatest.Run(t, func(t *testing.T) data.Repo {
+		return data.NewMemoryRepo().Cached(data.NewMovieCache(100, time.Minute))
+	})
+}
+
+func TestMovieCache(t *testing.T) {
+	ctx := context.Background()
+	c := data.NewMovieCache(100, time.Minute)
+	repo := data.NewMemoryRepo().Cached(c)
+
+	movie := &data.Movie{Title: "Alien", Year: 1979, Runtime: 117, Genres: []string{"sci-fi", "horror"}}
+	if err := repo.Movies.Insert(ctx, movie); err != nil {
+		t.Fatal(err)
+	}
+	list := func(title string, genres ...string) []*data.Movie {
+		t.Helper()
+		movies, _, err := repo.Movies.GetAll(ctx, title, genres, data.Filter{Page: 1, PageSize: 20, Sort: "id"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return movies
+	}
+
+	got, err := repo.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changes made by a caller must not leak into the cache.
+	got.Genres[0] = "changed"
+	got, _ = repo.Movies.Get(ctx, movie.ID)
+	if got.Genres[0] != "sci-fi" {
+		t.Errorf("the cached movie was modified through a returned copy: %v", got.Genres)
+	}
+	// Titles with the same lexemes and genres in any order share an entry.
+	list("alien", "sci-fi", "horror")
+	list("ALIEN!", "horror", "sci-fi")
+	if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("got %d hits and %d misses; want 2 and 2", stats.Hits, stats.Misses)
+	}
+
+	movie.Title = "Aliens"
+	if err := repo.Movies.Update(ctx, movie); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.Movies.Get(ctx, movie.ID); got.Title != "Aliens" {
+		t.Errorf("Get returned the stale title %q after Update", got.Title)
+	}
+	if movies := list("aliens"); len(movies) != 1 {
+		t.Errorf("GetAll found %d movies after Update; want 1", len(movies))
+	}
+
+	// Writes inside a transaction invalidate once it commits, and not if it rolls back.
+	errRollback := errors.New("rollback")
+	err = repo.WithTx(ctx, func(tx data.Repo) error {
+		if err := tx.Movies.Delete(ctx, movie.ID); err != nil {
+			t.Fatal(err)
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("got %v; want the rollback error", err)
+	}
+	if _, err := repo.Movies.Get(ctx, movie.ID); err != nil {
+		t.Fatalf("Get after a rolled back Delete: %v", err)
+	}
+	err = repo.WithTx(ctx, func(tx data.Repo) error {
+		return tx.Movies.Delete(ctx, movie.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Movies.Get(ctx, movie.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("Get after a committed Delete: got %v; want ErrRecordNotFound", err)
+	}
+}
+
+// primaryMovies records whether the reads it serves were routed to the primary.
+type primaryMovies struct {
+	data.MoviesRepoInterface
+	usedPrimary *bool
+}
+
+func (m primaryMovies) Get(ctx context.Context, id int64) (*data.Movie, error) {
+	*m.usedPrimary = data.UsesPrimary(ctx)
+	return m.MoviesRepoInterface.Get(ctx, id)
+}
+
+func (m primaryMovies) GetAll(ctx context.Context, title string, genres []string, filter data.Filter) ([]*data.Movie, data.Metadata, error) {
+	*m.usedPrimary = data.UsesPrimary(ctx)
+	return m.MoviesRepoInterface.GetAll(ctx, title, genres, filter)
+}
+
+func TestMovieCacheFillsFromPrimary(t *testing.T) {
+	ctx := context.Background()
+	var usedPrimary bool
+	repo := data.NewMemoryRepo()
+	repo.Movies = primaryMovies{repo.Movies, &usedPrimary}
+	repo = repo.Cached(data.NewMovieCache(100, time.Minute))
+
+	movie := &data.Movie{Title: "Alien", Year: 1979, Runtime: 117, Genres: []string{"sci-fi"}}
+	if err := repo.Movies.Insert(ctx, movie); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Movies.Get(ctx, movie.ID); err != nil || !usedPrimary {
+		t.Errorf("Get filled the cache from a replica (err %v)", err)
+	}
+	usedPrimary = false
+	_, _, err := repo.Movies.GetAll(ctx, "", nil, data.Filter{Page: 1, PageSize: 20, Sort: "id"})
+	if err != nil || !usedPrimary {
+		t.Errorf("GetAll filled the cache from a replica (err %v)", err)
+	}
+}
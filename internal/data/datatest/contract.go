@@ -0,0 +1,719 @@
+// Package datatest holds a conformance suite that every implementation of the
+// repository interfaces of package data must pass.
+package datatest
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"reflect"
+	"simplewebapi.moviedb/internal/data"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory returns an empty Repo. It is called once per test.
+type Factory func(t *testing.T) data.Repo
+
+// Run runs the whole suite against the repositories returned by newRepo.
+func Run(t *testing.T, newRepo Factory) {
+	t.Run("Movies", func(t *testing.T) { Movies(t, newRepo) })
+	t.Run("Users", func(t *testing.T) { Users(t, newRepo) })
+	t.Run("Tokens", func(t *testing.T) { Tokens(t, newRepo) })
+	t.Run("Idempotency", func(t *testing.T) { Idempotency(t, newRepo) })
+	t.Run("Tx", func(t *testing.T) { Tx(t, newRepo) })
+}
+
+func newMovie(title string, year int32, runtime data.Runtime, genres ...string) *data.Movie {
+	return &data.Movie{Title: title, Year: year, Runtime: runtime, Genres: genres}
+}
+
+// seedMovies inserts a small catalogue whose titles, years and runtimes are all
+// distinct, so that every sort order is fully determined.
+func seedMovies(t *testing.T, repo data.Repo) []*data.Movie {
+	t.Helper()
+	movies := []*data.Movie{
+		newMovie("Star Wars", 1977, 121, "sci-fi", "adventure"),
+		newMovie("The Empire Strikes Back", 1980, 124, "sci-fi", "adventure", "action"),
+		newMovie("Alien", 1979, 117, "sci-fi", "horror"),
+		newMovie("Star Trek", 2009, 127, "sci-fi", "action"),
+		newMovie("Casablanca", 1942, 102, "drama", "romance"),
+	}
+	for _, m := range movies {
+		err := repo.Movies.Insert(context.Background(), m)
+		if err != nil {
+			t.Fatalf("Insert(%q): %v", m.Title, err)
+		}
+	}
+	return movies
+}
+
+func titles(movies []*data.Movie) []string {
+	out := make([]string, len(movies))
+	for i, m := range movies {
+		out[i] = m.Title
+	}
+	return out
+}
+
+// Movies checks a MoviesRepoInterface implementation.
+func Movies(t *testing.T, newRepo Factory) {
+	ctx := context.Background()
+
+	t.Run("InsertAndGet", func(t *testing.T) {
+		repo := newRepo(t)
+		movie := newMovie("Alien", 1979, 117, "sci-fi", "horror")
+		err := repo.Movies.Insert(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movie.ID <= 0 || movie.Version != 1 || movie.CreatedAt.IsZero() || !movie.UpdatedAt.Equal(movie.CreatedAt) {
+			t.Fatalf("Insert did not fill id, version, created_at and updated_at: %+v", movie)
+		}
+
+		got, err := repo.Movies.Get(ctx, movie.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.CreatedAt.Equal(movie.CreatedAt) {
+			t.Errorf("created_at = %v, want %v", got.CreatedAt, movie.CreatedAt)
+		}
+		if !got.UpdatedAt.Equal(movie.UpdatedAt) {
+			t.Errorf("updated_at = %v, want %v", got.UpdatedAt, movie.UpdatedAt)
+		}
+		got.CreatedAt, got.UpdatedAt = movie.CreatedAt, movie.UpdatedAt
+		if !reflect.DeepEqual(got, movie) {
+			t.Errorf("Get = %+v, want %+v", got, movie)
+		}
+
+		other := newMovie("Aliens", 1986, 137, "sci-fi")
+		err = repo.Movies.Insert(ctx, other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.ID == movie.ID {
+			t.Errorf("two movies were given id %d", movie.ID)
+		}
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		repo := newRepo(t)
+		for _, id := range []int64{-1, 0, 1, 1 << 40} {
+			_, err := repo.Movies.Get(ctx, id)
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("Get(%d) error = %v, want ErrRecordNotFound", id, err)
+			}
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		repo := newRepo(t)
+		movie := newMovie("Alien", 1979, 117, "sci-fi")
+		err := repo.Movies.Insert(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stale := *movie
+		movie.Title = "Alien (Director's Cut)"
+		movie.Genres = []string{"sci-fi", "horror"}
+		err = repo.Movies.Update(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movie.Version != 2 {
+			t.Errorf("version = %d after update, want 2", movie.Version)
+		}
+		got, err := repo.Movies.Get(ctx, movie.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != movie.Title || !reflect.DeepEqual(got.Genres, movie.Genres) || got.Version != 2 {
+			t.Errorf("Get after update = %+v", got)
+		}
+		if movie.UpdatedAt.Before(stale.UpdatedAt) || !got.UpdatedAt.Equal(movie.UpdatedAt) {
+			t.Errorf("updated_at = %v after update and %v in Get, want the time of the update", movie.UpdatedAt, got.UpdatedAt)
+		}
+
+		stale.Title = "Lost update"
+		err = repo.Movies.Update(ctx, &stale)
+		if !errors.Is(err, data.ErrEditConflict) {
+			t.Errorf("Update with stale version error = %v, want ErrEditConflict", err)
+		}
+
+		missing := newMovie("Missing", 2000, 90, "drama")
+		missing.ID, missing.Version = movie.ID+1000, 1
+		err = repo.Movies.Update(ctx, missing)
+		if !errors.Is(err, data.ErrEditConflict) {
+			t.Errorf("Update of missing movie error = %v, want ErrEditConflict", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		repo := newRepo(t)
+		movie := newMovie("Alien", 1979, 117, "sci-fi")
+		err := repo.Movies.Insert(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Movies.Delete(ctx, movie.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = repo.Movies.Get(ctx, movie.ID)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("Get after delete error = %v, want ErrRecordNotFound", err)
+		}
+		err = repo.Movies.Delete(ctx, movie.ID)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("second Delete error = %v, want ErrRecordNotFound", err)
+		}
+	})
+
+	t.Run("DeleteVersion", func(t *testing.T) {
+		repo := newRepo(t)
+		movie := newMovie("Alien", 1979, 117, "sci-fi")
+		err := repo.Movies.Insert(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stale := movie.Version
+		err = repo.Movies.Update(ctx, movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Movies.DeleteVersion(ctx, movie.ID, stale)
+		if !errors.Is(err, data.ErrEditConflict) {
+			t.Errorf("DeleteVersion with stale version error = %v, want ErrEditConflict", err)
+		}
+		if _, err := repo.Movies.Get(ctx, movie.ID); err != nil {
+			t.Fatalf("Get after a conflicting DeleteVersion: %v", err)
+		}
+		err = repo.Movies.DeleteVersion(ctx, movie.ID, movie.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Movies.DeleteVersion(ctx, movie.ID, movie.Version)
+		if !errors.Is(err, data.ErrEditConflict) {
+			t.Errorf("DeleteVersion of missing movie error = %v, want ErrEditConflict", err)
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		repo := newRepo(t)
+		seedMovies(t, repo)
+
+		tests := []struct {
+			name   string
+			title  string
+			genres []string
+			want   []string
+		}{
+			{"all", "", nil, []string{"Star Wars", "The Empire Strikes Back", "Alien", "Star Trek", "Casablanca"}},
+			{"word", "star", nil, []string{"Star Wars", "Star Trek"}},
+			{"words in any order", "wars STAR", nil, []string{"Star Wars"}},
+			{"prefix is not a word", "sta", nil, []string{}},
+			{"one genre", "", []string{"horror"}, []string{"Alien"}},
+			{"genres are all required", "", []string{"sci-fi", "action"}, []string{"The Empire Strikes Back", "Star Trek"}},
+			{"unknown genre", "", []string{"western"}, []string{}},
+			{"title and genre", "star", []string{"action"}, []string{"Star Trek"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				genres := tt.genres
+				if genres == nil {
+					genres = []string{}
+				}
+				movies, metadata, err := repo.Movies.GetAll(ctx, tt.title, genres, data.Filter{Page: 1, PageSize: 20, Sort: "id"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := titles(movies); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("titles = %q, want %q", got, tt.want)
+				}
+				if metadata.TotalRecords != len(tt.want) {
+					t.Errorf("total_records = %d, want %d", metadata.TotalRecords, len(tt.want))
+				}
+			})
+		}
+	})
+
+	t.Run("Sort", func(t *testing.T) {
+		repo := newRepo(t)
+		seedMovies(t, repo)
+
+		tests := []struct {
+			sort string
+			want []string
+		}{
+			{"id", []string{"Star Wars", "The Empire Strikes Back", "Alien", "Star Trek", "Casablanca"}},
+			{"-id", []string{"Casablanca", "Star Trek", "Alien", "The Empire Strikes Back", "Star Wars"}},
+			{"title", []string{"Alien", "Casablanca", "Star Trek", "Star Wars", "The Empire Strikes Back"}},
+			{"-title", []string{"The Empire Strikes Back", "Star Wars", "Star Trek", "Casablanca", "Alien"}},
+			{"year", []string{"Casablanca", "Star Wars", "Alien", "The Empire Strikes Back", "Star Trek"}},
+			{"-runtime", []string{"Star Trek", "The Empire Strikes Back", "Star Wars", "Alien", "Casablanca"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.sort, func(t *testing.T) {
+				movies, _, err := repo.Movies.GetAll(ctx, "", []string{}, data.Filter{Page: 1, PageSize: 20, Sort: tt.sort})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := titles(movies); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("titles = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("Paging", func(t *testing.T) {
+		repo := newRepo(t)
+		seedMovies(t, repo)
+
+		tests := []struct {
+			name     string
+			filter   data.Filter
+			want     []string
+			metadata data.Metadata
+		}{
+			{
+				name:     "first page",
+				filter:   data.Filter{Page: 1, PageSize: 2, Sort: "year"},
+				want:     []string{"Casablanca", "Star Wars"},
+				metadata: data.Metadata{CurrentPage: 1, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5},
+			},
+			{
+				name:     "last partial page",
+				filter:   data.Filter{Page: 3, PageSize: 2, Sort: "year"},
+				want:     []string{"Star Trek"},
+				metadata: data.Metadata{CurrentPage: 3, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5},
+			},
+			{
+				name:     "exact fit",
+				filter:   data.Filter{Page: 1, PageSize: 5, Sort: "year"},
+				want:     []string{"Casablanca", "Star Wars", "Alien", "The Empire Strikes Back", "Star Trek"},
+				metadata: data.Metadata{CurrentPage: 1, PageSize: 5, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+			},
+			{
+				name:     "past the end",
+				filter:   data.Filter{Page: 4, PageSize: 2, Sort: "year"},
+				want:     []string{},
+				metadata: data.Metadata{},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				movies, metadata, err := repo.Movies.GetAll(ctx, "", []string{}, tt.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if movies == nil {
+					t.Error("GetAll returned a nil slice, want an empty one")
+				}
+				if got := titles(movies); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("titles = %q, want %q", got, tt.want)
+				}
+				if metadata != tt.metadata {
+					t.Errorf("metadata = %+v, want %+v", metadata, tt.metadata)
+				}
+			})
+		}
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := repo.Movies.Get(ctx, 1)
+		if !errors.Is(err, data.ErrCanceled) {
+			t.Errorf("Get with canceled context error = %v, want ErrCanceled", err)
+		}
+	})
+}
+
+func newUser(t *testing.T, name, email string) *data.User {
+	t.Helper()
+	user := &data.User{Name: name, Email: email}
+	// The lowest cost keeps the suite fast.
+	err := user.Password.Set("pa55word", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func insertUser(t *testing.T, repo data.Repo, name, email string) *data.User {
+	t.Helper()
+	user := newUser(t, name, email)
+	err := repo.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatalf("Insert(%q): %v", email, err)
+	}
+	return user
+}
+
+// Users checks a UsersRepoInterface implementation.
+func Users(t *testing.T, newRepo Factory) {
+	ctx := context.Background()
+
+	t.Run("InsertAndGetByEmail", func(t *testing.T) {
+		repo := newRepo(t)
+		user := insertUser(t, repo, "Alice", "alice@example.com")
+		if user.ID <= 0 || user.Version != 1 || !user.Activated || user.CreatedAt.IsZero() {
+			t.Fatalf("Insert did not fill id, version, activated and created_at: %+v", user)
+		}
+
+		got, err := repo.Users.GetByEmail(ctx, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID || got.Name != "Alice" || got.Version != 1 {
+			t.Errorf("GetByEmail = %+v, want %+v", got, user)
+		}
+		match, err := got.Password.Match("pa55word")
+		if err != nil || !match {
+			t.Errorf("stored password does not match: %v", err)
+		}
+
+		_, err = repo.Users.GetByEmail(ctx, "bob@example.com")
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("GetByEmail of unknown email error = %v, want ErrRecordNotFound", err)
+		}
+	})
+
+	t.Run("DuplicateEmail", func(t *testing.T) {
+		repo := newRepo(t)
+		insertUser(t, repo, "Alice", "alice@example.com")
+		for _, email := range []string{"alice@example.com", "Alice@Example.com"} {
+			err := repo.Users.Insert(ctx, newUser(t, "Impostor", email))
+			if !errors.Is(err, data.ErrDuplicateEmail) {
+				t.Errorf("Insert(%q) error = %v, want ErrDuplicateEmail", email, err)
+			}
+			checkConstraintError(t, err, data.UniqueConstraint, "users_email_key", "email")
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		repo := newRepo(t)
+		alice := insertUser(t, repo, "Alice", "alice@example.com")
+		bob := insertUser(t, repo, "Bob", "bob@example.com")
+
+		stale := *alice
+		alice.Name = "Alice Liddell"
+		err := repo.Users.Update(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alice.Version != 2 {
+			t.Errorf("version = %d after update, want 2", alice.Version)
+		}
+
+		err = repo.Users.Update(ctx, &stale)
+		if !errors.Is(err, data.ErrEditConflict) {
+			t.Errorf("Update with stale version error = %v, want ErrEditConflict", err)
+		}
+
+		bob.Email = "alice@example.com"
+		err = repo.Users.Update(ctx, bob)
+		if !errors.Is(err, data.ErrDuplicateEmail) {
+			t.Errorf("Update to a taken email error = %v, want ErrDuplicateEmail", err)
+		}
+		checkConstraintError(t, err, data.UniqueConstraint, "users_email_key", "email")
+	})
+}
+
+// Tokens checks a TokensRepoInterface implementation, together with
+// UsersRepoInterface.GetForToken.
+func Tokens(t *testing.T, newRepo Factory) {
+	ctx := context.Background()
+
+	t.Run("GetForToken", func(t *testing.T) {
+		repo := newRepo(t)
+		user := insertUser(t, repo, "Alice", "alice@example.com")
+		token, err := repo.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := repo.Users.GetForToken(ctx, data.ScopeAuthentication, token.Plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID {
+			t.Errorf("GetForToken returned user %d, want %d", got.ID, user.ID)
+		}
+
+		_, err = repo.Users.GetForToken(ctx, data.ScopeAuthentication, "AAAAAAAAAAAAAAAAAAAAAAAAAA")
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("GetForToken of unknown token error = %v, want ErrRecordNotFound", err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		repo := newRepo(t)
+		user := insertUser(t, repo, "Alice", "alice@example.com")
+		token, err := repo.Tokens.New(ctx, user.ID, -time.Minute, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = repo.Users.GetForToken(ctx, data.ScopeAuthentication, token.Plaintext)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("GetForToken of expired token error = %v, want ErrRecordNotFound", err)
+		}
+	})
+
+	t.Run("ScopeSeparation", func(t *testing.T) {
+		repo := newRepo(t)
+		user := insertUser(t, repo, "Alice", "alice@example.com")
+		auth, err := repo.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		activation, err := repo.Tokens.New(ctx, user.ID, time.Hour, data.ScopeActivation)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = repo.Users.GetForToken(ctx, data.ScopeActivation, auth.Plaintext)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("authentication token accepted as activation token: %v", err)
+		}
+		_, err = repo.Users.GetForToken(ctx, data.ScopeAuthentication, auth.Plaintext)
+		if err != nil {
+			t.Errorf("new activation token invalidated the authentication token: %v", err)
+		}
+
+		err = repo.Tokens.DeleteAllForUser(ctx, data.ScopeActivation, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = repo.Users.GetForToken(ctx, data.ScopeActivation, activation.Plaintext)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("GetForToken after DeleteAllForUser error = %v, want ErrRecordNotFound", err)
+		}
+		_, err = repo.Users.GetForToken(ctx, data.ScopeAuthentication, auth.Plaintext)
+		if err != nil {
+			t.Errorf("DeleteAllForUser(activation) removed the authentication token: %v", err)
+		}
+	})
+
+	t.Run("NewReplacesTokensOfTheSameScope", func(t *testing.T) {
+		repo := newRepo(t)
+		user := insertUser(t, repo, "Alice", "alice@example.com")
+		first, err := repo.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := repo.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = repo.Users.GetForToken(ctx, data.ScopeAuthentication, first.Plaintext)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("replaced token still valid: %v", err)
+		}
+		_, err = repo.Users.GetForToken(ctx, data.ScopeAuthentication, second.Plaintext)
+		if err != nil {
+			t.Errorf("new token rejected: %v", err)
+		}
+	})
+
+	t.Run("UnknownUser", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.Tokens.New(ctx, 1<<40, time.Hour, data.ScopeAuthentication)
+		if err == nil {
+			t.Fatal("New for a user that does not exist succeeded")
+		}
+		checkConstraintError(t, err, data.ForeignKeyConstraint, "tokens_user_id_fkey", "user_id")
+	})
+}
+
+// checkConstraintError reports an error unless err is a *data.ConstraintError for the
+// given constraint of the schema, tied to the given input field.
+func checkConstraintError(t *testing.T, err error, kind data.ConstraintKind, constraint, field string) {
+	t.Helper()
+	var constraintErr *data.ConstraintError
+	if !errors.As(err, &constraintErr) {
+		t.Errorf("error = %v, want a *data.ConstraintError", err)
+		return
+	}
+	if constraintErr.Kind != kind || constraintErr.Constraint != constraint || constraintErr.Field != field {
+		t.Errorf("constraint error = %s %q on field %q, want %s %q on field %q",
+			constraintErr.Kind, constraintErr.Constraint, constraintErr.Field, kind, constraint, field)
+	}
+}
+
+// Idempotency checks an IdempotencyRepoInterface implementation.
+func Idempotency(t *testing.T, newRepo Factory) {
+	ctx := context.Background()
+	newRecord := func(client, key string) *data.IdempotencyRecord {
+		return &data.IdempotencyRecord{
+			Client:      client,
+			Key:         key,
+			Method:      "POST",
+			Path:        "/movies",
+			Fingerprint: data.Fingerprint("POST", "/movies", []byte(key)),
+			Expiry:      time.Now().Add(time.Hour),
+		}
+	}
+
+	t.Run("ReserveAndComplete", func(t *testing.T) {
+		repo := newRepo(t)
+		record := newRecord("alice", "key")
+		existing, err := repo.Idempotency.Reserve(ctx, record)
+		if err != nil || existing != nil {
+			t.Fatalf("Reserve of a new key = %v, %v; want nil, nil", existing, err)
+		}
+		existing, err = repo.Idempotency.Reserve(ctx, newRecord("alice", "key"))
+		if err != nil || existing == nil || !existing.InFlight() {
+			t.Fatalf("Reserve of a key in flight = %+v, %v; want the in-flight record", existing, err)
+		}
+
+		record.Status = 201
+		record.Headers = map[string][]string{"Location": {"/v1/movies/1"}}
+		record.Body = []byte(`{"movie": {}}`)
+		err = repo.Idempotency.Complete(ctx, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		existing, err = repo.Idempotency.Reserve(ctx, newRecord("alice", "key"))
+		if err != nil || existing == nil {
+			t.Fatalf("Reserve of a completed key = %v, %v; want the stored record", existing, err)
+		}
+		if existing.Status != 201 || !reflect.DeepEqual(existing.Headers, record.Headers) ||
+			string(existing.Body) != string(record.Body) || string(existing.Fingerprint) != string(record.Fingerprint) {
+			t.Errorf("stored record = %+v, want %+v", existing, record)
+		}
+	})
+
+	t.Run("ScopedByClient", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.Idempotency.Reserve(ctx, newRecord("alice", "key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		existing, err := repo.Idempotency.Reserve(ctx, newRecord("bob", "key"))
+		if err != nil || existing != nil {
+			t.Errorf("another client's Reserve of the same key = %+v, %v; want nil, nil", existing, err)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		repo := newRepo(t)
+		record := newRecord("alice", "key")
+		_, err := repo.Idempotency.Reserve(ctx, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Idempotency.Release(ctx, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		existing, err := repo.Idempotency.Reserve(ctx, newRecord("alice", "key"))
+		if err != nil || existing != nil {
+			t.Errorf("Reserve after Release = %+v, %v; want nil, nil", existing, err)
+		}
+	})
+
+	t.Run("ReserveRacingRelease", func(t *testing.T) {
+		repo := newRepo(t)
+		// Requests reusing a key while its holders fail and release it must see the key
+		// either free or held, never an error.
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 50 {
+					record := newRecord("alice", "key")
+					existing, err := repo.Idempotency.Reserve(ctx, record)
+					if err != nil {
+						t.Errorf("Reserve racing Release: %v", err)
+						return
+					}
+					if existing != nil {
+						continue
+					}
+					err = repo.Idempotency.Release(ctx, record)
+					if err != nil {
+						t.Errorf("Release: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		repo := newRepo(t)
+		record := newRecord("alice", "key")
+		record.Expiry = time.Now().Add(-time.Minute)
+		_, err := repo.Idempotency.Reserve(ctx, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		existing, err := repo.Idempotency.Reserve(ctx, newRecord("alice", "key"))
+		if err != nil || existing != nil {
+			t.Errorf("Reserve of an expired key = %+v, %v; want nil, nil", existing, err)
+		}
+		err = repo.Idempotency.DeleteExpired(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// Tx checks that Repo.WithTx commits or rolls back the work of every repository at once.
+func Tx(t *testing.T, newRepo Factory) {
+	ctx := context.Background()
+
+	t.Run("Commit", func(t *testing.T) {
+		repo := newRepo(t)
+		var token *data.Token
+		err := repo.WithTx(ctx, func(tx data.Repo) error {
+			user := newUser(t, "Alice", "alice@example.com")
+			err := tx.Users.Insert(ctx, user)
+			if err != nil {
+				return err
+			}
+			token, err = tx.Tokens.New(ctx, user.ID, time.Hour, data.ScopeActivation)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = repo.Users.GetForToken(ctx, data.ScopeActivation, token.Plaintext)
+		if err != nil {
+			t.Errorf("token created in a committed transaction: %v", err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		repo := newRepo(t)
+		errAbort := errors.New("abort")
+		err := repo.WithTx(ctx, func(tx data.Repo) error {
+			err := tx.Users.Insert(ctx, newUser(t, "Alice", "alice@example.com"))
+			if err != nil {
+				return err
+			}
+			err = tx.Movies.Insert(ctx, newMovie("Alien", 1979, 117, "sci-fi"))
+			if err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("WithTx error = %v, want the error returned by fn", err)
+		}
+		_, err = repo.Users.GetByEmail(ctx, "alice@example.com")
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("user inserted in a rolled back transaction: %v", err)
+		}
+		_, err = repo.Movies.Get(ctx, 1)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("movie inserted in a rolled back transaction: %v", err)
+		}
+	})
+}
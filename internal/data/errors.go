@@ -0,0 +1,174 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+)
+
+var (
+	// ErrSerializationFailure and ErrDeadlock abort a transaction that collided with
+	// another one; running it again may succeed.
+	ErrSerializationFailure = errors.New("serialization failure")
+	ErrDeadlock             = errors.New("deadlock detected")
+	// ErrLockTimeout is returned when a row or table lock could not be taken in time.
+	ErrLockTimeout = errors.New("lock timeout")
+)
+
+// ConstraintKind tells which kind of constraint a write violated.
+type ConstraintKind string
+
+const (
+	UniqueConstraint     ConstraintKind = "unique"
+	CheckConstraint      ConstraintKind = "check"
+	ForeignKeyConstraint ConstraintKind = "foreign key"
+	NotNullConstraint    ConstraintKind = "not null"
+)
+
+// ConstraintError reports a write rejected by a database constraint. Field names the
+// input field the constraint guards, as used by ValidateMovie and ValidateUser, and is
+// empty when the constraint is not tied to user input.
+type ConstraintError struct {
+	Kind       ConstraintKind
+	Constraint string
+	Table      string
+	Field      string
+	Message    string
+
+	// domain is the error of this package the constraint stands for, if any.
+	domain error
+	err    error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%s constraint %q violated: %s", e.Kind, e.Constraint, e.err)
+}
+
+// Unwrap returns the domain error, such as ErrDuplicateEmail, and the driver error.
+func (e *ConstraintError) Unwrap() []error {
+	if e.domain != nil {
+		return []error{e.domain, e.err}
+	}
+	return []error{e.err}
+}
+
+// constraint describes a named constraint of the schema created by the migrations.
+type constraint struct {
+	field   string
+	message string
+	domain  error
+}
+
+var constraints = map[string]constraint{
+	"users_email_key":      {field: "email", message: "a user with this email address already exists", domain: ErrDuplicateEmail},
+	"movies_runtime_check": {field: "runtime", message: "must be a positive integer"},
+	"movies_year_check":    {field: "year", message: "must be between 1888 and the current year"},
+	"tokens_user_id_fkey":  {field: "user_id", message: "user does not exist"},
+}
+
+// sqliteConstraints maps the error text of SQLite, which names columns rather than
+// constraints, to the constraints of the PostgreSQL schema. SQLite does not report which
+// foreign key failed, so those errors name no constraint; see nameForeignKey.
+var sqliteConstraints = []struct {
+	text       string
+	kind       ConstraintKind
+	constraint string
+}{
+	{"UNIQUE constraint failed: users.email", UniqueConstraint, "users_email_key"},
+	{"CHECK constraint failed: movies_runtime_check", CheckConstraint, "movies_runtime_check"},
+	{"CHECK constraint failed: movies_year_check", CheckConstraint, "movies_year_check"},
+	{"FOREIGN KEY constraint failed", ForeignKeyConstraint, ""},
+}
+
+// translateError turns the database errors that callers can act on into the errors
+// of this package, keeping the driver error in the chain.
+func translateError(err error) error {
+	var constraintErr *ConstraintError
+	if errors.As(err, &constraintErr) || errors.Is(err, ErrSerializationFailure) ||
+		errors.Is(err, ErrDeadlock) || errors.Is(err, ErrLockTimeout) || errors.Is(err, ErrTimeout) {
+		return err
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return translateSQLiteError(err)
+	}
+	switch pqErr.Code {
+	case "40001":
+		return fmt.Errorf("%w: %w", ErrSerializationFailure, err)
+	case "40P01":
+		return fmt.Errorf("%w: %w", ErrDeadlock, err)
+	case "55P03":
+		return fmt.Errorf("%w: %w", ErrLockTimeout, err)
+	case "57014":
+		// query_canceled, raised when statement_timeout runs out.
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+
+	var kind ConstraintKind
+	switch pqErr.Code {
+	case "23505":
+		kind = UniqueConstraint
+	case "23514":
+		kind = CheckConstraint
+	case "23503":
+		kind = ForeignKeyConstraint
+	case "23502":
+		kind = NotNullConstraint
+	default:
+		return err
+	}
+	e := &ConstraintError{
+		Kind:       kind,
+		Constraint: pqErr.Constraint,
+		Table:      pqErr.Table,
+		Field:      pqErr.Column,
+		Message:    strings.TrimPrefix(pqErr.Message, "pq: "),
+		err:        err,
+	}
+	if c, ok := constraints[pqErr.Constraint]; ok {
+		e.Field = c.field
+		e.Message = c.message
+		e.domain = c.domain
+	}
+	return e
+}
+
+// translateSQLiteError does the work of translateError for SQLite, whose drivers all
+// report the error text of the library.
+func translateSQLiteError(err error) error {
+	msg := err.Error()
+	if strings.Contains(msg, "database is locked") {
+		return fmt.Errorf("%w: %w", ErrLockTimeout, err)
+	}
+	for _, c := range sqliteConstraints {
+		if !strings.Contains(msg, c.text) {
+			continue
+		}
+		return constraintError(c.kind, c.constraint, "", err)
+	}
+	return err
+}
+
+// constraintError returns the error reporting that err violated the named constraint
+// of the schema, filled in from constraints when the constraint is known there.
+func constraintError(kind ConstraintKind, name, table string, err error) *ConstraintError {
+	e := &ConstraintError{Kind: kind, Constraint: name, Table: table, Message: err.Error(), err: err}
+	if known, ok := constraints[name]; ok {
+		e.Field = known.field
+		e.Message = known.message
+		e.domain = known.domain
+	}
+	return e
+}
+
+// nameForeignKey names the constraint of a foreign key error that names none, as those
+// of SQLite, for statements that can only violate the one foreign key.
+func nameForeignKey(err error, name, table string) error {
+	var e *ConstraintError
+	if errors.As(err, &e) && e.Kind == ForeignKeyConstraint && e.Constraint == "" {
+		return constraintError(ForeignKeyConstraint, name, table, e.err)
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"testing"
+)
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		want       error
+		kind       ConstraintKind
+		constraint string
+		field      string
+	}{
+		{name: "DuplicateEmail", err: &pq.Error{Code: "23505", Constraint: "users_email_key"},
+			want: ErrDuplicateEmail, kind: UniqueConstraint, constraint: "users_email_key", field: "email"},
+		{name: "RuntimeCheck", err: &pq.Error{Code: "23514", Constraint: "movies_runtime_check"},
+			kind: CheckConstraint, constraint: "movies_runtime_check", field: "runtime"},
+		{name: "UnknownForeignKey", err: &pq.Error{Code: "23503", Constraint: "other_fkey", Column: "other_id"},
+			kind: ForeignKeyConstraint, constraint: "other_fkey", field: "other_id"},
+		{name: "SerializationFailure", err: &pq.Error{Code: "40001"}, want: ErrSerializationFailure},
+		{name: "Deadlock", err: &pq.Error{Code: "40P01"}, want: ErrDeadlock},
+		{name: "LockTimeout", err: &pq.Error{Code: "55P03"}, want: ErrLockTimeout},
+		{name: "StatementTimeout", err: &pq.Error{Code: "57014"}, want: ErrTimeout},
+		{name: "SQLiteDuplicateEmail", err: errors.New("constraint failed: UNIQUE constraint failed: users.email (2067)"),
+			want: ErrDuplicateEmail, kind: UniqueConstraint, constraint: "users_email_key", field: "email"},
+		{name: "SQLiteYearCheck", err: errors.New("CHECK constraint failed: movies_year_check (1811)"),
+			kind: CheckConstraint, constraint: "movies_year_check", field: "year"},
+		{name: "SQLiteForeignKey", err: errors.New("constraint failed: FOREIGN KEY constraint failed (787)"),
+			kind: ForeignKeyConstraint},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dbError(context.Background(), fmt.Errorf("wrapped: %w", tt.err))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("the driver error is missing from %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+			var constraintErr *ConstraintError
+			if !errors.As(err, &constraintErr) {
+				if tt.kind != "" {
+					t.Fatalf("error = %v, want a ConstraintError", err)
+				}
+				return
+			}
+			if constraintErr.Kind != tt.kind || constraintErr.Constraint != tt.constraint || constraintErr.Field != tt.field {
+				t.Errorf("got %s constraint %q on %q, want %s constraint %q on %q",
+					constraintErr.Kind, constraintErr.Constraint, constraintErr.Field, tt.kind, tt.constraint, tt.field)
+			}
+			if again := dbError(context.Background(), err); again != err {
+				t.Errorf("translating twice changed the error to %v", again)
+			}
+		})
+	}
+}
+
+func TestDBErrorContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want error
+	}{
+		{"Canceled", canceled, context.Canceled, ErrCanceled},
+		{"Deadline", expired, context.DeadlineExceeded, ErrTimeout},
+		{"DeadlineFromDriver", context.Background(), fmt.Errorf("query: %w", context.DeadlineExceeded), ErrTimeout},
+		{"CanceledStatement", canceled, &pq.Error{Code: "57014", Message: "canceling statement due to user request"}, ErrCanceled},
+		{"StatementTimeout", context.Background(), &pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"}, ErrTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dbError(tt.ctx, tt.err)
+			if !errors.Is(err, tt.want) || !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v wrapping %v", err, tt.want, tt.err)
+			}
+			if again := dbError(tt.ctx, err); again != err {
+				t.Errorf("translating twice changed the error to %v", again)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrNoMigrations = errors.New("no migrations have been applied")
+
+// Ping checks that the database can be reached.
+func (r Repo) Ping(ctx context.Context) error {
+	if r.db == nil {
+		return nil
+	}
+	return dbError(ctx, r.db.PingContext(ctx))
+}
+
+// MigrationVersion reports the version recorded in the schema_migrations table and
+// whether the last migration failed halfway, leaving the schema dirty.
+func (r Repo) MigrationVersion(ctx context.Context) (int64, bool, error) {
+	if r.db == nil {
+		return 0, false, ErrNoMigrations
+	}
+	var (
+		version int64
+		dirty   bool
+	)
+	err := r.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, ErrNoMigrations
+		default:
+			return 0, false, dbError(ctx, err)
+		}
+	}
+	return version, dirty, nil
+}
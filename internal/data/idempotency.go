@@ -0,0 +1,36 @@
+package data
+
+import (
+	"crypto/sha256"
+	"time"
+)
+
+type IdempotencyRecord struct {
+	// Client identifies the sender of the request, so that clients choosing the same
+	// key never see each other's responses.
+	Client      string
+	Key         string
+	Method      string
+	Path        string
+	Fingerprint []byte
+	Status      int // zero while the original request is still in flight
+	Headers     map[string][]string
+	Body        []byte
+	Expiry      time.Time
+}
+
+func (rec *IdempotencyRecord) InFlight() bool {
+	return rec.Status == 0
+}
+
+// Fingerprint identifies a request by its method, path and body so that a key
+// reused with a different payload can be told apart from a genuine retry.
+func Fingerprint(method, path string, body []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return h.Sum(nil)
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+type IdempotencyRepoInterface interface {
+	Reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error)
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	Release(ctx context.Context, record *IdempotencyRecord) error
+	DeleteExpired(ctx context.Context) error
+}
+
+type IdempotencyRepo struct {
+	DB       DBTX
+	Timeouts Timeouts
+}
+
+// Reserve claims the key of record for a new request. If the key is already held
+// by an unexpired record, nothing is written and the stored record is returned instead.
+func (repo IdempotencyRepo) Reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	for {
+		existing, err := repo.reserve(ctx, record)
+		// The key was held when the insert ran but released before it could be read,
+		// so it may be free now. Every retry follows a release by another request.
+		if !errors.Is(err, sql.ErrNoRows) {
+			return existing, err
+		}
+		if ctx.Err() != nil {
+			return nil, dbError(ctx, ctx.Err())
+		}
+	}
+}
+
+func (repo IdempotencyRepo) reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	query := `INSERT INTO idempotency_keys (client, key, method, path, fingerprint, expiry)
+			VALUES ($1,$2,$3,$4,$5,$6)
+			ON CONFLICT (client, key, method, path) DO UPDATE
+				SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = NULL, body = NULL,
+					created_at = NOW(), expiry = EXCLUDED.expiry
+				WHERE idempotency_keys.expiry <= NOW()
+			RETURNING key`
+
+	args := []interface{}{record.Client, record.Key, record.Method, record.Path, record.Fingerprint, record.Expiry}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	var key string
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&key)
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, dbError(ctx, err)
+	}
+
+	query = `SELECT fingerprint, status, headers, body, expiry
+			FROM idempotency_keys
+			WHERE client = $1 AND key = $2 AND method = $3 AND path = $4`
+
+	var (
+		existing = IdempotencyRecord{Client: record.Client, Key: record.Key, Method: record.Method, Path: record.Path}
+		status   sql.NullInt64
+		headers  []byte
+	)
+	err = repo.DB.QueryRowContext(ctx, query, record.Client, record.Key, record.Method, record.Path).Scan(
+		&existing.Fingerprint,
+		&status,
+		&headers,
+		&existing.Body,
+		&existing.Expiry,
+	)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	existing.Status = int(status.Int64)
+	if headers != nil {
+		err = json.Unmarshal(headers, &existing.Headers)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+	}
+	return &existing, nil
+}
+
+func (repo IdempotencyRepo) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	query := `UPDATE idempotency_keys
+			SET status = $1, headers = $2, body = $3
+			WHERE client = $4 AND key = $5 AND method = $6 AND path = $7`
+
+	headers, err := json.Marshal(record.Headers)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	args := []interface{}{record.Status, headers, record.Body, record.Client, record.Key, record.Method, record.Path}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	_, err = repo.DB.ExecContext(ctx, query, args...)
+	return dbError(ctx, err)
+}
+
+// Release drops a reservation so that the request can be retried, e.g. after a server error.
+func (repo IdempotencyRepo) Release(ctx context.Context, record *IdempotencyRecord) error {
+	query := `DELETE FROM idempotency_keys
+			WHERE client = $1 AND key = $2 AND method = $3 AND path = $4 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, query, record.Client, record.Key, record.Method, record.Path)
+	return dbError(ctx, err)
+}
+
+func (repo IdempotencyRepo) DeleteExpired(ctx context.Context) error {
+	query := `DELETE FROM idempotency_keys WHERE expiry <= NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, query)
+	return dbError(ctx, err)
+}
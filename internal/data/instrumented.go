@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryClass groups statements that share database-side limits.
+type QueryClass string
+
+const (
+	ReadQuery  QueryClass = "read"
+	WriteQuery QueryClass = "write"
+	// OtherQuery covers statements such as DDL and SET, which get no limits of their own.
+	OtherQuery QueryClass = "other"
+)
+
+// ClassifyQuery tells the class of a statement from its leading keyword. A WITH
+// query is a write when it contains a data-modifying statement.
+func ClassifyQuery(query string) QueryClass {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return OtherQuery
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT":
+		return ReadQuery
+	case "INSERT", "UPDATE", "DELETE":
+		return WriteQuery
+	case "WITH":
+		for _, f := range fields[1:] {
+			switch strings.ToUpper(strings.TrimLeft(f, "(")) {
+			case "INSERT", "UPDATE", "DELETE":
+				return WriteQuery
+			}
+		}
+		return ReadQuery
+	}
+	return OtherQuery
+}
+
+var (
+	whitespaceRX    = regexp.MustCompile(`\s+`)
+	stringLiteralRX = regexp.MustCompile(`'(?:[^']|'')*'`)
+	placeholderRX   = regexp.MustCompile(`\$\d+|\?`)
+	numberLiteralRX = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	normalizedCache sync.Map // query -> normalized query
+)
+
+// NormalizeQuery collapses whitespace and replaces placeholders and literals with ?,
+// so that the statements built from one query text, such as the LIMIT and OFFSET of
+// GetAll, are reported as one.
+func NormalizeQuery(query string) string {
+	if normalized, ok := normalizedCache.Load(query); ok {
+		return normalized.(string)
+	}
+	normalized := strings.TrimSpace(whitespaceRX.ReplaceAllString(query, " "))
+	normalized = stringLiteralRX.ReplaceAllString(normalized, "?")
+	normalized = placeholderRX.ReplaceAllString(normalized, "?")
+	normalized = numberLiteralRX.ReplaceAllString(normalized, "?")
+	normalizedCache.Store(query, normalized)
+	return normalized
+}
+
+// QueryStat describes one statement run by the repositories.
+type QueryStat struct {
+	Query    string // normalized, see NormalizeQuery
+	Class    QueryClass
+	Duration time.Duration
+	Err      error
+}
+
+// Instrumented returns a copy of repo whose repositories report every statement they
+// run, including inside WithTx and on replicas added afterwards, to observe. It must be
+// applied before WithReplicas and Traced; repositories that do not use SQL are returned
+// unchanged.
+func Instrumented(repo Repo, observe func(ctx context.Context, stat QueryStat)) Repo {
+	if repo.db == nil || repo.build == nil {
+		return repo
+	}
+	build := repo.build
+	instrumented := repo
+	instrumented.build = func(db DBTX, timeouts Timeouts) Repo {
+		return build(instrumentedDB{db, observe}, timeouts)
+	}
+	built := instrumented.build(repo.db, repo.timeouts)
+	instrumented.Movies = built.Movies
+	instrumented.Users = built.Users
+	instrumented.Tokens = built.Tokens
+	instrumented.Idempotency = built.Idempotency
+	return instrumented
+}
+
+type instrumentedDB struct {
+	next    DBTX
+	observe func(ctx context.Context, stat QueryStat)
+}
+
+func (db instrumentedDB) record(ctx context.Context, query string, start time.Time, err error) {
+	db.observe(ctx, QueryStat{
+		Query:    NormalizeQuery(query),
+		Class:    ClassifyQuery(query),
+		Duration: time.Since(start),
+		Err:      err,
+	})
+}
+
+func (db instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := db.next.ExecContext(ctx, query, args...)
+	db.record(ctx, query, start, err)
+	return result, err
+}
+
+// QueryContext reports the time taken to get the first rows, not to read all of them.
+func (db instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.next.QueryContext(ctx, query, args...)
+	db.record(ctx, query, start, err)
+	return rows, err
+}
+
+func (db instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := db.next.QueryRowContext(ctx, query, args...)
+	db.record(ctx, query, start, row.Err())
+	return row
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		class QueryClass
+	}{
+		{"SELECT id, title\n\t\tFROM movies\n\t\tWHERE id = $1", "SELECT id, title FROM movies WHERE id = ?", ReadQuery},
+		{"SELECT count(*) OVER(), id FROM movies ORDER BY id ASC LIMIT 20 OFFSET 40",
+			"SELECT count(*) OVER(), id FROM movies ORDER BY id ASC LIMIT ? OFFSET ?", ReadQuery},
+		{"  insert into tokens (hash, scope) values (?, 'it''s')", "insert into tokens (hash, scope) values (?, ?)", WriteQuery},
+		{"WITH moved AS (DELETE FROM tokens RETURNING *) SELECT count(*) FROM moved",
+			"WITH moved AS (DELETE FROM tokens RETURNING *) SELECT count(*) FROM moved", WriteQuery},
+		{"CREATE INDEX movies_title_idx ON movies (title)", "CREATE INDEX movies_title_idx ON movies (title)", OtherQuery},
+	}
+	for _, tt := range tests {
+		if got := NormalizeQuery(tt.query); got != tt.want {
+			t.Errorf("NormalizeQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+		if got := ClassifyQuery(tt.query); got != tt.class {
+			t.Errorf("ClassifyQuery(%q) = %q, want %q", tt.query, got, tt.class)
+		}
+	}
+}
+
+// recordingConn is a driver connection that records the statements it runs.
+type recordingConn struct {
+	driver.Conn
+	statements []string
+}
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.statements = append(c.statements, query)
+	return driver.RowsAffected(0), nil
+}
+
+type recordingTx struct{}
+
+func (recordingTx) Commit() error   { return nil }
+func (recordingTx) Rollback() error { return nil }
+
+func (c *recordingConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return recordingTx{}, nil
+}
+
+func TestSessionLimits(t *testing.T) {
+	inner := &recordingConn{}
+	conn := &sessionConn{Conn: inner, limits: map[QueryClass]SessionLimits{
+		ReadQuery:  {StatementTimeout: 5000e6, LockTimeout: 1000e6},
+		WriteQuery: {StatementTimeout: 3000e6, LockTimeout: 1000e6},
+	}}
+	ctx := context.Background()
+	exec := func(query string) {
+		t.Helper()
+		_, err := conn.ExecContext(ctx, query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exec("SELECT 1")
+	exec("SELECT 2")
+	exec("UPDATE movies SET year = 1")
+	exec("CREATE TABLE t (id int)")
+	tx, err := conn.BeginTx(ctx, driver.TxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec("SELECT 3")
+	tx.Rollback()
+	exec("SELECT 4")
+
+	want := []string{
+		"SET statement_timeout = 5000; SET lock_timeout = 1000", "SELECT 1", "SELECT 2",
+		"SET statement_timeout = 3000; SET lock_timeout = 1000", "UPDATE movies SET year = 1",
+		"CREATE TABLE t (id int)",
+		"SET statement_timeout = 5000; SET lock_timeout = 1000", "SELECT 3",
+		// The rollback undid the last SET.
+		"SET statement_timeout = 5000; SET lock_timeout = 1000", "SELECT 4",
+	}
+	if !reflect.DeepEqual(inner.statements, want) {
+		t.Errorf("statements run:\n%q\nwant:\n%q", inner.statements, want)
+	}
+}
@@ -0,0 +1,478 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// NewMemoryRepo returns a Repo that keeps everything in memory. It mirrors the
+// behaviour of the PostgreSQL repositories closely enough for tests and for running
+// the API locally without a database. Transactions are serialized and take an
+// exclusive lock on the whole store until they commit or roll back.
+func NewMemoryRepo() Repo {
+	db := &memoryDB{
+		movies:      make(map[int64]Movie),
+		users:       make(map[int64]User),
+		tokens:      make(map[string]Token),
+		idempotency: make(map[idempotencyKey]IdempotencyRecord),
+	}
+	repo := newMemoryRepo(memoryConn{db: db})
+	repo.runTx = db.withTx
+	return repo
+}
+
+func newMemoryRepo(conn memoryConn) Repo {
+	return Repo{
+		Movies:      memoryMovies{conn},
+		Users:       memoryUsers{conn},
+		Tokens:      memoryTokens{conn},
+		Idempotency: memoryIdempotency{conn},
+	}
+}
+
+type idempotencyKey struct {
+	client, key, method, path string
+}
+
+type memoryDB struct {
+	mu          sync.RWMutex
+	movies      map[int64]Movie
+	users       map[int64]User
+	tokens      map[string]Token
+	idempotency map[idempotencyKey]IdempotencyRecord
+	lastMovieID int64
+	lastUserID  int64
+}
+
+// withTx runs fn holding the store lock, restoring the previous contents if fn fails.
+func (db *memoryDB) withTx(ctx context.Context, fn func(Repo) error) error {
+	if err := ctx.Err(); err != nil {
+		return dbError(ctx, err)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snapshot := memoryDB{
+		movies:      maps.Clone(db.movies),
+		users:       maps.Clone(db.users),
+		tokens:      maps.Clone(db.tokens),
+		idempotency: maps.Clone(db.idempotency),
+		lastMovieID: db.lastMovieID,
+		lastUserID:  db.lastUserID,
+	}
+	err := fn(newMemoryRepo(memoryConn{db: db, inTx: true}))
+	if err == nil {
+		err = dbError(ctx, ctx.Err())
+	}
+	if err != nil {
+		db.movies, db.users, db.tokens, db.idempotency = snapshot.movies, snapshot.users, snapshot.tokens, snapshot.idempotency
+		db.lastMovieID, db.lastUserID = snapshot.lastMovieID, snapshot.lastUserID
+	}
+	return err
+}
+
+// memoryConn gives the repositories access to the store. Inside a transaction the
+// lock is already held, so the repositories must not take it again.
+type memoryConn struct {
+	db   *memoryDB
+	inTx bool
+}
+
+func (c memoryConn) read(ctx context.Context, fn func(db *memoryDB) error) error {
+	if err := ctx.Err(); err != nil {
+		return dbError(ctx, err)
+	}
+	if !c.inTx {
+		c.db.mu.RLock()
+		defer c.db.mu.RUnlock()
+	}
+	return fn(c.db)
+}
+
+func (c memoryConn) write(ctx context.Context, fn func(db *memoryDB) error) error {
+	if err := ctx.Err(); err != nil {
+		return dbError(ctx, err)
+	}
+	if !c.inTx {
+		c.db.mu.Lock()
+		defer c.db.mu.Unlock()
+	}
+	return fn(c.db)
+}
+
+// now matches the precision of the timestamp(0) columns.
+func now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+func copyMovie(m Movie) *Movie {
+	m.Genres = append([]string(nil), m.Genres...)
+	return &m
+}
+
+func copyUser(u User) *User {
+	u.Password = password{hash: append([]byte(nil), u.Password.hash...)}
+	return &u
+}
+
+type memoryMovies struct {
+	memoryConn
+}
+
+func (repo memoryMovies) Insert(ctx context.Context, movie *Movie) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		db.lastMovieID++
+		movie.ID = db.lastMovieID
+		movie.CreatedAt = now()
+		movie.UpdatedAt = movie.CreatedAt
+		movie.Version = 1
+		db.movies[movie.ID] = *copyMovie(*movie)
+		return nil
+	})
+}
+
+func (repo memoryMovies) Get(ctx context.Context, id int64) (*Movie, error) {
+	if id <= 0 {
+		return nil, ErrRecordNotFound
+	}
+	var movie *Movie
+	err := repo.read(ctx, func(db *memoryDB) error {
+		m, ok := db.movies[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+		movie = copyMovie(m)
+		return nil
+	})
+	return movie, err
+}
+
+func (repo memoryMovies) GetAll(ctx context.Context, title string, genres []string, filter Filter) ([]*Movie, Metadata, error) {
+	var matches []*Movie
+	err := repo.read(ctx, func(db *memoryDB) error {
+		for _, m := range db.movies {
+			if matchTitle(m.Title, title) && containsAll(m.Genres, genres) {
+				matches = append(matches, copyMovie(m))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	column := strings.TrimPrefix(filter.Sort, "-")
+	desc := filter.sortDirection() == "DESC"
+	sort.Slice(matches, func(i, j int) bool {
+		c := compareMovies(matches[i], matches[j], column)
+		if c == 0 {
+			return matches[i].ID < matches[j].ID
+		}
+		return (c < 0) != desc
+	})
+
+	total := len(matches)
+	movies := make([]*Movie, 0)
+	if offset := filter.offset(); offset < total {
+		end := min(offset+filter.limit(), total)
+		movies = append(movies, matches[offset:end]...)
+	}
+	// Like count(*) OVER() in PostgreSQL, the total is unknown when the page is empty.
+	if len(movies) == 0 {
+		total = 0
+	}
+	return movies, NewMetadata(total, filter.Page, filter.PageSize), nil
+}
+
+func compareMovies(a, b *Movie, column string) int {
+	switch column {
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "year":
+		return int(a.Year) - int(b.Year)
+	case "runtime":
+		return int(a.Runtime) - int(b.Runtime)
+	default:
+		return int(a.ID - b.ID)
+	}
+}
+
+// matchTitle mirrors to_tsvector('simple', title) @@ plainto_tsquery('simple', query):
+// every word of query must appear as a word of title, ignoring case.
+func matchTitle(title, query string) bool {
+	words := make(map[string]bool)
+	for _, w := range lexemes(title) {
+		words[w] = true
+	}
+	for _, w := range lexemes(query) {
+		if !words[w] {
+			return false
+		}
+	}
+	return true
+}
+
+func lexemes(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsAll mirrors the genres @> $2 array containment.
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (repo memoryMovies) Update(ctx context.Context, movie *Movie) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		stored, ok := db.movies[movie.ID]
+		if !ok || stored.Version != movie.Version {
+			return ErrEditConflict
+		}
+		movie.Version++
+		movie.UpdatedAt = now()
+		updated := *copyMovie(*movie)
+		updated.CreatedAt = stored.CreatedAt
+		db.movies[movie.ID] = updated
+		return nil
+	})
+}
+
+func (repo memoryMovies) Delete(ctx context.Context, id int64) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		if _, ok := db.movies[id]; !ok {
+			return ErrRecordNotFound
+		}
+		delete(db.movies, id)
+		return nil
+	})
+}
+
+func (repo memoryMovies) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		stored, ok := db.movies[id]
+		if !ok || stored.Version != version {
+			return ErrEditConflict
+		}
+		delete(db.movies, id)
+		return nil
+	})
+}
+
+type memoryUsers struct {
+	memoryConn
+}
+
+// emailTaken mirrors the unique constraint on the case-insensitive citext email column.
+func (db *memoryDB) emailTaken(email string, except int64) bool {
+	for id, u := range db.users {
+		if id != except && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo memoryUsers) Insert(ctx context.Context, user *User) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		if db.emailTaken(user.Email, 0) {
+			return constraintError(UniqueConstraint, "users_email_key", "users", errEmailTaken)
+		}
+		db.lastUserID++
+		user.ID = db.lastUserID
+		user.CreatedAt = now()
+		user.Activated = true
+		user.Version = 1
+		db.users[user.ID] = *copyUser(*user)
+		return nil
+	})
+}
+
+func (repo memoryUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	var user *User
+	err := repo.read(ctx, func(db *memoryDB) error {
+		for _, u := range db.users {
+			if strings.EqualFold(u.Email, email) {
+				user = copyUser(u)
+				return nil
+			}
+		}
+		return ErrRecordNotFound
+	})
+	return user, err
+}
+
+func (repo memoryUsers) Update(ctx context.Context, user *User) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		stored, ok := db.users[user.ID]
+		if !ok || stored.Version != user.Version {
+			return ErrEditConflict
+		}
+		if db.emailTaken(user.Email, user.ID) {
+			return constraintError(UniqueConstraint, "users_email_key", "users", errEmailTaken)
+		}
+		user.Version++
+		updated := *copyUser(*user)
+		updated.CreatedAt = stored.CreatedAt
+		db.users[user.ID] = updated
+		return nil
+	})
+}
+
+func (repo memoryUsers) GetForToken(ctx context.Context, scope string, token string) (*User, error) {
+	hash := sha256.Sum256([]byte(token))
+	var user *User
+	err := repo.read(ctx, func(db *memoryDB) error {
+		t, ok := db.tokens[string(hash[:])]
+		if !ok || t.Scope != scope || !t.Expiry.After(time.Now()) {
+			return ErrRecordNotFound
+		}
+		u, ok := db.users[t.UserID]
+		if !ok {
+			return ErrRecordNotFound
+		}
+		user = copyUser(u)
+		return nil
+	})
+	return user, err
+}
+
+type memoryTokens struct {
+	memoryConn
+}
+
+// The memory backend checks the constraints of the schema itself, and reports their
+// violations with the same errors as the SQL backends.
+var (
+	errEmailTaken         = errors.New("email is already taken")
+	errDuplicateTokenHash = errors.New("token hash already exists")
+	errTokenUserMissing   = errors.New("token user does not exist")
+)
+
+func (repo memoryTokens) Insert(ctx context.Context, token *Token) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		if _, ok := db.tokens[string(token.Hash)]; ok {
+			return constraintError(UniqueConstraint, "tokens_pkey", "tokens", errDuplicateTokenHash)
+		}
+		if _, ok := db.users[token.UserID]; !ok {
+			return constraintError(ForeignKeyConstraint, "tokens_user_id_fkey", "tokens", errTokenUserMissing)
+		}
+		stored := *token
+		stored.Plaintext = ""
+		stored.Hash = append([]byte(nil), token.Hash...)
+		stored.Expiry = token.Expiry.Truncate(time.Second)
+		db.tokens[string(stored.Hash)] = stored
+		return nil
+	})
+}
+
+func (repo memoryTokens) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, fmt.Errorf("generate token: %w", err)
+	}
+	// A user holds at most one token of each scope.
+	err = repo.DeleteAllForUser(ctx, scope, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = repo.Insert(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (repo memoryTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		for hash, t := range db.tokens {
+			if t.Scope == scope && t.UserID == userID {
+				delete(db.tokens, hash)
+			}
+		}
+		return nil
+	})
+}
+
+type memoryIdempotency struct {
+	memoryConn
+}
+
+func (repo memoryIdempotency) Reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	var existing *IdempotencyRecord
+	err := repo.write(ctx, func(db *memoryDB) error {
+		key := idempotencyKey{record.Client, record.Key, record.Method, record.Path}
+		stored, ok := db.idempotency[key]
+		if ok && stored.Expiry.After(time.Now()) {
+			existing = &stored
+			return nil
+		}
+		db.idempotency[key] = IdempotencyRecord{
+			Client:      record.Client,
+			Key:         record.Key,
+			Method:      record.Method,
+			Path:        record.Path,
+			Fingerprint: record.Fingerprint,
+			Expiry:      record.Expiry.Truncate(time.Second),
+		}
+		return nil
+	})
+	return existing, err
+}
+
+func (repo memoryIdempotency) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		key := idempotencyKey{record.Client, record.Key, record.Method, record.Path}
+		stored, ok := db.idempotency[key]
+		if !ok {
+			return nil
+		}
+		stored.Status = record.Status
+		stored.Headers = record.Headers
+		stored.Body = record.Body
+		db.idempotency[key] = stored
+		return nil
+	})
+}
+
+func (repo memoryIdempotency) Release(ctx context.Context, record *IdempotencyRecord) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		key := idempotencyKey{record.Client, record.Key, record.Method, record.Path}
+		if stored, ok := db.idempotency[key]; ok && stored.InFlight() {
+			delete(db.idempotency, key)
+		}
+		return nil
+	})
+}
+
+func (repo memoryIdempotency) DeleteExpired(ctx context.Context) error {
+	return repo.write(ctx, func(db *memoryDB) error {
+		now := time.Now()
+		for key, r := range db.idempotency {
+			if !r.Expiry.After(now) {
+				delete(db.idempotency, key)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,13 @@
+package data_test
+
+import (
+	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/data/datatest"
+	"testing"
+)
+
+func TestMemoryRepo(t *testing.T) {
+	datatest.Run(t, func(t *testing.T) data.Repo {
+		return data.NewMemoryRepo()
+	})
+}
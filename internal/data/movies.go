@@ -12,6 +12,7 @@ import (
 type Movie struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
 	Title     string    `json:"title"`
 	Year      int32     `json:"year"`
 	Runtime   Runtime   `json:"runtime,omitempty"`
@@ -7,56 +7,53 @@ import (
 	"fmt"
 	"github.com/lib/pq"
 	"strings"
-	"time"
 )
 
 type MoviesRepoInterface interface {
-	Insert(movie *Movie) error
-	Get(id int64) (*Movie, error)
-	GetAll(title string, genres []string, filter Filter) ([]*Movie, Metadata, error)
-	Update(movie *Movie) error
-	Delete(id int64) error
+	Insert(ctx context.Context, movie *Movie) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	GetAll(ctx context.Context, title string, genres []string, filter Filter) ([]*Movie, Metadata, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, id int64) error
+	DeleteVersion(ctx context.Context, id int64, version int32) error
 }
 type MoviesRepo struct {
-	DB *sql.DB
+	DB       DBTX
+	Timeouts Timeouts
 }
 
-func (repo MoviesRepo) Insert(movie *Movie) error {
+func (repo MoviesRepo) Insert(ctx context.Context, movie *Movie) error {
 	query := `
 		INSERT INTO movies (title,year,runtime,genres)
 		VALUES ($1,$2,$3,$4)
-		RETURNING id,created_at,version`
+		RETURNING id,created_at,updated_at,version`
 
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
 	defer cancel()
 
-	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		default:
-			return err
-		}
+		return dbError(ctx, err)
 	}
 	return nil
 }
-func (repo MoviesRepo) Get(id int64) (*Movie, error) {
+func (repo MoviesRepo) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id <= 0 {
 		return nil, ErrRecordNotFound
 	}
 	var movie Movie
-	query := `SELECT id,created_at,title,year,runtime, genres,version
+	query := `SELECT id,created_at,updated_at,title,year,runtime, genres,version
 			FROM movies
 			WHERE id=$1`
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Read)
 
 	defer cancel()
 	err := repo.DB.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
+		&movie.UpdatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
@@ -67,32 +64,33 @@ func (repo MoviesRepo) Get(id int64) (*Movie, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, dbError(ctx, err)
 		}
 	}
 	return &movie, nil
 }
 
-func (repo MoviesRepo) GetAll(title string, genres []string, filter Filter) ([]*Movie, Metadata, error) {
+func (repo MoviesRepo) GetAll(ctx context.Context, title string, genres []string, filter Filter) ([]*Movie, Metadata, error) {
 
-	sortBy := fmt.Sprintf("%s %s", strings.TrimPrefix(filter.Sort, "-"), filter.sortDirection())
+	// id breaks ties so that pages do not overlap or skip rows.
+	sortBy := fmt.Sprintf("%s %s, id ASC", strings.TrimPrefix(filter.Sort, "-"), filter.sortDirection())
 	limit := filter.limit()
 	offset := filter.offset()
 
-	query := fmt.Sprintf(`SELECT  count(*) OVER(),id, created_at, title, year, runtime, genres, version
+	query := fmt.Sprintf(`SELECT  count(*) OVER(),id, created_at, updated_at, title, year, runtime, genres, version
         FROM movies
         WHERE (to_tsvector('simple',title) @@ plainto_tsquery('simple',$1) OR $1='')
         	AND ((genres @> $2) OR $2='{}')
         ORDER BY %s
         LIMIT %v OFFSET %v`, sortBy, limit, offset)
 	// genres && $2 : nếu cần exists in
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Read)
 	defer cancel()
 
 	args := []interface{}{title, pq.Array(genres)}
 	rows, err := repo.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, dbError(ctx, err)
 	}
 	defer rows.Close()
 	movies := make([]*Movie, 0)
@@ -103,6 +101,7 @@ func (repo MoviesRepo) GetAll(title string, genres []string, filter Filter) ([]*
 			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
+			&movie.UpdatedAt,
 			&movie.Title,
 			&movie.Year,
 			&movie.Runtime,
@@ -110,23 +109,23 @@ func (repo MoviesRepo) GetAll(title string, genres []string, filter Filter) ([]*
 			&movie.Version,
 		)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, Metadata{}, dbError(ctx, err)
 		}
 		movies = append(movies, &movie)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, dbError(ctx, err)
 	}
 	metadata := NewMetadata(totalRecords, filter.Page, filter.PageSize)
 	return movies, metadata, nil
 }
 
-func (repo MoviesRepo) Update(movie *Movie) error {
+func (repo MoviesRepo) Update(ctx context.Context, movie *Movie) error {
 	query := `
         UPDATE movies 
-        SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
+        SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1, updated_at = NOW()
         WHERE id = $5 AND version = $6
-        RETURNING version`
+        RETURNING version, updated_at`
 
 	args := []interface{}{
 		movie.Title,
@@ -136,35 +135,57 @@ func (repo MoviesRepo) Update(movie *Movie) error {
 		movie.ID,
 		movie.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
 	defer cancel()
-	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return dbError(ctx, err)
 		}
 	}
 	return nil
 }
-func (repo MoviesRepo) Delete(id int64) error {
+func (repo MoviesRepo) Delete(ctx context.Context, id int64) error {
 	query := `DELETE FROM movies
 			WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
 	defer cancel()
 	result, err := repo.DB.ExecContext(ctx, query, id)
 	if err != nil {
-		return err
+		return dbError(ctx, err)
 	}
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return dbError(ctx, err)
 	}
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
 	return nil
 }
+
+// DeleteVersion deletes the movie only if it is still at version, and returns
+// ErrEditConflict when it has changed or no longer exists, like Update.
+func (repo MoviesRepo) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	query := `DELETE FROM movies
+			WHERE id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+	result, err := repo.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
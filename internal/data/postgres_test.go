@@ -0,0 +1,45 @@
+package data_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	_ "github.com/lib/pq"
+	"os"
+	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/data/datatest"
+	"simplewebapi.moviedb/internal/migrate"
+	"simplewebapi.moviedb/migrations"
+	"testing"
+)
+
+// TestPostgresRepo runs the contract suite against the database named by
+// MOVIE_API_TEST_DB_DSN. Every table is truncated, so never point it at real data.
+func TestPostgresRepo(t *testing.T) {
+	dsn := os.Getenv("MOVIE_API_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("MOVIE_API_TEST_DB_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Up(context.Background())
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatal(err)
+	}
+
+	datatest.Run(t, func(t *testing.T) data.Repo {
+		_, err := db.Exec(`TRUNCATE movies, users, tokens, idempotency_keys RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data.NewRepo(db, data.DefaultTimeouts)
+	})
+}
@@ -0,0 +1,211 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Replicas is a set of read-only copies of the primary database. Reads routed to it
+// are spread over the replicas that passed their last health check, and go to the
+// primary when there are none.
+type Replicas struct {
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// NewReplicas returns a set of replicas, all of them assumed healthy until checked.
+func NewReplicas(dbs ...*sql.DB) *Replicas {
+	rs := &Replicas{}
+	for _, db := range dbs {
+		r := &replica{db: db}
+		r.healthy.Store(true)
+		rs.replicas = append(rs.replicas, r)
+	}
+	return rs
+}
+
+// Close closes every replica.
+func (rs *Replicas) Close() error {
+	var errs []error
+	for _, r := range rs.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Len returns the number of replicas, healthy or not.
+func (rs *Replicas) Len() int {
+	return len(rs.replicas)
+}
+
+// Healthy returns the number of replicas that passed their last health check.
+func (rs *Replicas) Healthy() int {
+	n := 0
+	for _, r := range rs.replicas {
+		if r.healthy.Load() {
+			n++
+		}
+	}
+	return n
+}
+
+// Check pings every replica with the given timeout and records whether it answered.
+// report, if not nil, is called for every replica whose health changed.
+func (rs *Replicas) Check(ctx context.Context, timeout time.Duration, report func(index int, healthy bool, err error)) {
+	for i, r := range rs.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := r.db.PingContext(pingCtx)
+		cancel()
+		if r.healthy.Swap(err == nil) != (err == nil) && report != nil {
+			report(i, err == nil, err)
+		}
+	}
+}
+
+// Monitor runs Check every interval until done is closed.
+func (rs *Replicas) Monitor(interval, timeout time.Duration, done <-chan struct{}, report func(index int, healthy bool, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			rs.Check(context.Background(), timeout, report)
+		}
+	}
+}
+
+// pick returns the index of the next healthy replica, or -1 if there is none.
+func (rs *Replicas) pick() int {
+	n := len(rs.replicas)
+	start := rs.next.Add(1)
+	for i := 0; i < n; i++ {
+		index := int((start + uint64(i)) % uint64(n))
+		if rs.replicas[index].healthy.Load() {
+			return index
+		}
+	}
+	return -1
+}
+
+// markDown takes a replica out of rotation until its next successful health check.
+func (rs *Replicas) markDown(index int) {
+	rs.replicas[index].healthy.Store(false)
+}
+
+type primaryKey struct{}
+
+// UsePrimary returns a context whose reads are not routed to replicas, for a caller
+// that must see its own recent writes.
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// UsesPrimary reports whether ctx was returned by UsePrimary.
+func UsesPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// WithReplicas returns a copy of r whose Movies.Get, Movies.GetAll and Users.GetByEmail
+// run on rs. Writes, token lookups and everything inside WithTx stay on the primary.
+// A read that fails on a replica for a reason other than its outcome takes the replica
+// out of rotation and is retried on the primary.
+func (r Repo) WithReplicas(rs *Replicas) Repo {
+	if rs == nil || rs.Len() == 0 || r.build == nil {
+		return r
+	}
+	route := router{replicas: rs}
+	for _, replica := range rs.replicas {
+		route.repos = append(route.repos, r.build(replica.db, r.timeouts))
+	}
+	r.Movies = routedMovies{route, r.Movies}
+	r.Users = routedUsers{route, r.Users}
+	return r
+}
+
+type router struct {
+	replicas *Replicas
+	repos    []Repo
+}
+
+// read runs fn on a replica when one is healthy and ctx allows it, and on the
+// primary otherwise or when the replica failed.
+func (rt router) read(ctx context.Context, fn func(Repo) error, primary func() error) error {
+	if UsesPrimary(ctx) {
+		return primary()
+	}
+	index := rt.replicas.pick()
+	if index < 0 {
+		return primary()
+	}
+	err := fn(rt.repos[index])
+	if err == nil || isOutcome(err) || ctx.Err() != nil {
+		return err
+	}
+	rt.replicas.markDown(index)
+	return primary()
+}
+
+// isOutcome reports whether err is an answer from the database rather than a failure.
+func isOutcome(err error) bool {
+	return errors.Is(err, ErrRecordNotFound) || errors.Is(err, ErrCanceled)
+}
+
+type routedMovies struct {
+	router
+	MoviesRepoInterface
+}
+
+func (m routedMovies) Get(ctx context.Context, id int64) (*Movie, error) {
+	var movie *Movie
+	err := m.read(ctx, func(r Repo) (err error) {
+		movie, err = r.Movies.Get(ctx, id)
+		return err
+	}, func() (err error) {
+		movie, err = m.MoviesRepoInterface.Get(ctx, id)
+		return err
+	})
+	return movie, err
+}
+
+func (m routedMovies) GetAll(ctx context.Context, title string, genres []string, filter Filter) ([]*Movie, Metadata, error) {
+	var (
+		movies   []*Movie
+		metadata Metadata
+	)
+	err := m.read(ctx, func(r Repo) (err error) {
+		movies, metadata, err = r.Movies.GetAll(ctx, title, genres, filter)
+		return err
+	}, func() (err error) {
+		movies, metadata, err = m.MoviesRepoInterface.GetAll(ctx, title, genres, filter)
+		return err
+	})
+	return movies, metadata, err
+}
+
+type routedUsers struct {
+	router
+	UsersRepoInterface
+}
+
+func (u routedUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	var user *User
+	err := u.read(ctx, func(r Repo) (err error) {
+		user, err = r.Users.GetByEmail(ctx, email)
+		return err
+	}, func() (err error) {
+		user, err = u.UsersRepoInterface.GetByEmail(ctx, email)
+		return err
+	})
+	return user, err
+}
@@ -1,25 +1,202 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrCanceled       = errors.New("operation canceled")
+	// ErrTimeout is returned when an operation ran out of time, either on its own
+	// timeout or on a statement timeout of the database.
+	ErrTimeout = errors.New("operation timed out")
 )
 
+// Timeouts bound how long a single repository operation may run.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Read:  5 * time.Second,
+	Write: 3 * time.Second,
+}
+
+// dbError translates err into the errors of this package, see translateError, and
+// marks it as ErrCanceled when it was caused by ctx being canceled, which usually
+// means the client went away, or as ErrTimeout when ctx ran out of time.
+func dbError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	err = translateError(err)
+	switch {
+	case errors.Is(err, ErrCanceled):
+	// PostgreSQL reports a canceled statement like a timed out one, whatever canceled it.
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
+	case errors.Is(err, ErrTimeout):
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
+
+// DBTX is the part of *sql.DB and *sql.Tx used by the repositories, so the same
+// repository code can run inside or outside of a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type Repo struct {
-	Movies MoviesRepoInterface
-	Users  UsersRepoInterface
-	Tokens TokensRepoInterface
+	Movies      MoviesRepoInterface
+	Users       UsersRepoInterface
+	Tokens      TokensRepoInterface
+	Idempotency IdempotencyRepoInterface
+
+	db         *sql.DB
+	timeouts   Timeouts
+	txAttempts int
+	decorate   func(Repo) Repo
+	runTx      func(ctx context.Context, fn func(Repo) error) error
+	// build creates the repositories of the same backend on top of a transaction.
+	build func(db DBTX, timeouts Timeouts) Repo
+	// onCommit collects the functions to run once the transaction of a Repo passed
+	// to a WithTx callback has committed; it is nil outside of transactions.
+	onCommit *[]func()
+}
+
+func NewRepo(db *sql.DB, timeouts Timeouts) Repo {
+	repo := newRepo(db, timeouts)
+	repo.db = db
+	repo.timeouts = timeouts
+	repo.txAttempts = DefaultTxAttempts
+	repo.build = newRepo
+	return repo
 }
 
-func NewRepo(db *sql.DB) Repo {
+func newRepo(db DBTX, timeouts Timeouts) Repo {
 	return Repo{
-		Movies: MoviesRepo{DB: db},
-		Users:  UsersRepo{DB: db},
-		Tokens: TokensRepo{DB: db},
+		Movies:      MoviesRepo{DB: db, Timeouts: timeouts},
+		Users:       UsersRepo{DB: db, Timeouts: timeouts},
+		Tokens:      TokensRepo{DB: db, Timeouts: timeouts},
+		Idempotency: IdempotencyRepo{DB: db, Timeouts: timeouts},
+	}
+}
+
+// DefaultTxAttempts bounds how many times WithTx runs a transaction that failed with a
+// serialization failure or a deadlock, unless WithTxAttempts sets another bound.
+const DefaultTxAttempts = 3
+
+// WithTxAttempts returns a copy of r whose WithTx runs a transaction at most n times.
+func (r Repo) WithTxAttempts(n int) Repo {
+	r.txAttempts = n
+	return r
+}
+
+// WithTx runs fn with a Repo whose repositories share a single transaction.
+// The transaction is committed if fn returns nil and rolled back otherwise.
+// When the database aborts it with a serialization failure or a deadlock, the whole
+// transaction is retried, so fn must not have side effects outside of the Repo.
+func (r Repo) WithTx(ctx context.Context, fn func(Repo) error) error {
+	if r.runTx != nil {
+		var hooks []func()
+		err := r.runTx(ctx, func(txRepo Repo) error {
+			hooks = nil
+			txRepo.onCommit = &hooks
+			if r.decorate != nil {
+				txRepo = r.decorate(txRepo)
+			}
+			return fn(txRepo)
+		})
+		if err == nil {
+			runHooks(hooks)
+		}
+		return err
+	}
+	if r.db == nil {
+		return errors.New("repository does not support transactions")
 	}
+	for attempt := 1; ; attempt++ {
+		err := r.runTxOnce(ctx, fn)
+		if attempt >= r.txAttempts || !isRetryable(err) {
+			return err
+		}
+		// Back off with jitter so that the transactions that collided do not collide again.
+		backoff := time.Duration(rand.Int64N(int64(10*time.Millisecond) << attempt))
+		select {
+		case <-ctx.Done():
+			return dbError(ctx, err)
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (r Repo) runTxOnce(ctx context.Context, fn func(Repo) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var hooks []func()
+	txRepo := r.build(tx, r.timeouts)
+	txRepo.onCommit = &hooks
+	if r.decorate != nil {
+		txRepo = r.decorate(txRepo)
+	}
+	err = fn(txRepo)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	runHooks(hooks)
+	return nil
+}
+
+// Wrap returns a copy of r whose repositories are replaced by those returned by fn,
+// including the repositories passed to WithTx callbacks.
+func (r Repo) Wrap(fn func(Repo) Repo) Repo {
+	decorate := r.decorate
+	r = fn(r)
+	r.decorate = func(tx Repo) Repo {
+		if decorate != nil {
+			tx = decorate(tx)
+		}
+		return fn(tx)
+	}
+	return r
+}
+
+// afterCommit runs fn once the transaction r belongs to has committed, and right away
+// when r is not part of a transaction. fn is dropped if the transaction rolls back.
+func (r Repo) afterCommit(fn func()) {
+	if r.onCommit == nil {
+		fn()
+		return
+	}
+	*r.onCommit = append(*r.onCommit, fn)
+}
+
+func runHooks(hooks []func()) {
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// isRetryable reports whether err aborted a transaction that may succeed if run again.
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrSerializationFailure) || errors.Is(err, ErrDeadlock)
 }
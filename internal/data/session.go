@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// SessionLimits are the database-side limits of one class of statements.
+type SessionLimits struct {
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+}
+
+// statement returns the PostgreSQL commands applying the limits; zero disables a limit.
+func (l SessionLimits) statement() string {
+	return fmt.Sprintf("SET statement_timeout = %d; SET lock_timeout = %d",
+		l.StatementTimeout.Milliseconds(), l.LockTimeout.Milliseconds())
+}
+
+// WithSessionLimits returns a PostgreSQL connector whose connections apply the limits
+// of the class of every statement, see ClassifyQuery, before running it. The limits
+// are session settings, so they are only sent when a connection switches class.
+// Statements of a class without limits run under those of the previous statement.
+func WithSessionLimits(connector driver.Connector, limits map[QueryClass]SessionLimits) driver.Connector {
+	return sessionConnector{next: connector, limits: limits}
+}
+
+type sessionConnector struct {
+	next   driver.Connector
+	limits map[QueryClass]SessionLimits
+}
+
+func (c sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.next.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionConn{Conn: conn, limits: c.limits}, nil
+}
+
+func (c sessionConnector) Driver() driver.Driver {
+	return c.next.Driver()
+}
+
+// sessionConn remembers the class whose limits are in effect on the connection.
+// database/sql never uses a connection concurrently, so it needs no lock.
+type sessionConn struct {
+	driver.Conn
+	limits map[QueryClass]SessionLimits
+	class  QueryClass
+}
+
+func (c *sessionConn) apply(ctx context.Context, query string) error {
+	class := ClassifyQuery(query)
+	limits, ok := c.limits[class]
+	if !ok || class == c.class {
+		return nil
+	}
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil
+	}
+	_, err := execer.ExecContext(ctx, limits.statement(), nil)
+	if err != nil {
+		return err
+	}
+	c.class = class
+	return nil
+}
+
+func (c *sessionConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	err := c.apply(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *sessionConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	err := c.apply(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c *sessionConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	err := c.apply(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *sessionConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sessionTx{Tx: tx, conn: c}, nil
+}
+
+func (c *sessionConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sessionConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sessionConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// sessionTx forgets the class of its connection on rollback, which also undoes any
+// SET run inside the transaction.
+type sessionTx struct {
+	driver.Tx
+	conn *sessionConn
+}
+
+func (tx sessionTx) Rollback() error {
+	tx.conn.class = ""
+	return tx.Tx.Rollback()
+}
@@ -0,0 +1,499 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NewSQLiteRepo returns a Repo storing its data in the SQLite database db, whose
+// schema is created by migrations.SQLiteFS. It behaves like the PostgreSQL
+// repositories: genres are kept as a JSON array, titles are searched with FTS5 and
+// emails are unique regardless of case.
+//
+// No SQLite driver is linked by this package; the caller opens db with one.
+func NewSQLiteRepo(db *sql.DB, timeouts Timeouts) Repo {
+	repo := newSQLiteRepo(db, timeouts)
+	repo.db = db
+	repo.timeouts = timeouts
+	repo.txAttempts = DefaultTxAttempts
+	repo.build = newSQLiteRepo
+	return repo
+}
+
+func newSQLiteRepo(db DBTX, timeouts Timeouts) Repo {
+	return Repo{
+		Movies:      SQLiteMoviesRepo{DB: db, Timeouts: timeouts},
+		Users:       SQLiteUsersRepo{DB: db, Timeouts: timeouts},
+		Tokens:      SQLiteTokensRepo{DB: db, Timeouts: timeouts},
+		Idempotency: SQLiteIdempotencyRepo{DB: db, Timeouts: timeouts},
+	}
+}
+
+// ftsQuery turns a title search into an FTS5 query with the meaning of
+// plainto_tsquery('simple', title): every word must be present.
+func ftsQuery(title string) string {
+	words := lexemes(title)
+	for i, w := range words {
+		words[i] = `"` + w + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+type SQLiteMoviesRepo struct {
+	DB       DBTX
+	Timeouts Timeouts
+}
+
+func (repo SQLiteMoviesRepo) Insert(ctx context.Context, movie *Movie) error {
+	query := `
+		INSERT INTO movies (title, year, runtime, genres, updated_at)
+		VALUES (?, ?, ?, ?, CAST(strftime('%s', 'now') AS integer))
+		RETURNING id, created_at, updated_at, version`
+
+	genres, err := json.Marshal(movie.Genres)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, string(genres)}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	var createdAt, updatedAt int64
+	err = repo.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &createdAt, &updatedAt, &movie.Version)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	movie.CreatedAt = time.Unix(createdAt, 0)
+	movie.UpdatedAt = time.Unix(updatedAt, 0)
+	return nil
+}
+
+// scanMovie reads the id, created_at, updated_at, title, year, runtime, genres and version columns.
+func scanMovie(scan func(dest ...interface{}) error, dest ...interface{}) (*Movie, error) {
+	var (
+		movie     Movie
+		createdAt int64
+		updatedAt int64
+		genres    string
+	)
+	dest = append(dest, &movie.ID, &createdAt, &updatedAt, &movie.Title, &movie.Year, &movie.Runtime, &genres, &movie.Version)
+	err := scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	movie.CreatedAt = time.Unix(createdAt, 0)
+	movie.UpdatedAt = time.Unix(updatedAt, 0)
+	err = json.Unmarshal([]byte(genres), &movie.Genres)
+	if err != nil {
+		return nil, err
+	}
+	return &movie, nil
+}
+
+func (repo SQLiteMoviesRepo) Get(ctx context.Context, id int64) (*Movie, error) {
+	if id <= 0 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT id, created_at, updated_at, title, year, runtime, genres, version
+			FROM movies
+			WHERE id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	movie, err := scanMovie(repo.DB.QueryRowContext(ctx, query, id).Scan)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, dbError(ctx, err)
+		}
+	}
+	return movie, nil
+}
+
+func (repo SQLiteMoviesRepo) GetAll(ctx context.Context, title string, genres []string, filter Filter) ([]*Movie, Metadata, error) {
+	match := ftsQuery(title)
+	if title != "" && match == "" {
+		// Like an empty tsquery, a title without any word matches nothing.
+		return []*Movie{}, Metadata{}, nil
+	}
+
+	// id breaks ties so that pages do not overlap or skip rows.
+	sortBy := fmt.Sprintf("%s %s, id ASC", strings.TrimPrefix(filter.Sort, "-"), filter.sortDirection())
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, updated_at, title, year, runtime, genres, version
+		FROM movies
+		WHERE (? = '' OR id IN (SELECT rowid FROM movies_fts WHERE movies_fts MATCH ?))
+			AND NOT EXISTS (
+				SELECT 1 FROM json_each(?) AS wanted
+				WHERE wanted.value NOT IN (SELECT value FROM json_each(movies.genres)))
+		ORDER BY %s
+		LIMIT %d OFFSET %d`, sortBy, filter.limit(), filter.offset())
+
+	wanted, err := json.Marshal(genres)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if genres == nil {
+		wanted = []byte("[]")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, match, match, string(wanted))
+	if err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+	defer rows.Close()
+
+	movies := make([]*Movie, 0)
+	var totalRecords int
+	for rows.Next() {
+		movie, err := scanMovie(rows.Scan, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, dbError(ctx, err)
+		}
+		movies = append(movies, movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+	return movies, NewMetadata(totalRecords, filter.Page, filter.PageSize), nil
+}
+
+func (repo SQLiteMoviesRepo) Update(ctx context.Context, movie *Movie) error {
+	query := `
+		UPDATE movies
+		SET title = ?, year = ?, runtime = ?, genres = ?, version = version + 1,
+			updated_at = CAST(strftime('%s', 'now') AS integer)
+		WHERE id = ? AND version = ?
+		RETURNING version, updated_at`
+
+	genres, err := json.Marshal(movie.Genres)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, string(genres), movie.ID, movie.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	var updatedAt int64
+	err = repo.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &updatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return dbError(ctx, err)
+		}
+	}
+	movie.UpdatedAt = time.Unix(updatedAt, 0)
+	return nil
+}
+
+func (repo SQLiteMoviesRepo) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM movies WHERE id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (repo SQLiteMoviesRepo) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	query := `DELETE FROM movies WHERE id = ? AND version = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+type SQLiteUsersRepo struct {
+	DB       DBTX
+	Timeouts Timeouts
+}
+
+func (repo SQLiteUsersRepo) Insert(ctx context.Context, user *User) error {
+	query := `INSERT INTO users (name, email, password_hash)
+			VALUES (?, ?, ?)
+			RETURNING id, created_at, activated, version`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	var createdAt int64
+	err := repo.DB.QueryRowContext(ctx, query, user.Name, user.Email, user.Password.hash).Scan(
+		&user.ID, &createdAt, &user.Activated, &user.Version)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	user.CreatedAt = time.Unix(createdAt, 0)
+	return nil
+}
+
+// scanUser reads the id, created_at, name, email, password_hash, activated and version columns.
+func scanUser(row *sql.Row) (*User, error) {
+	var (
+		user      User
+		createdAt int64
+	)
+	err := row.Scan(&user.ID, &createdAt, &user.Name, &user.Email, &user.Password.hash, &user.Activated, &user.Version)
+	if err != nil {
+		return nil, err
+	}
+	user.CreatedAt = time.Unix(createdAt, 0)
+	return &user, nil
+}
+
+func (repo SQLiteUsersRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `SELECT id, created_at, name, email, password_hash, activated, version
+			FROM users
+			WHERE email = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	user, err := scanUser(repo.DB.QueryRowContext(ctx, query, email))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, dbError(ctx, err)
+		}
+	}
+	return user, nil
+}
+
+func (repo SQLiteUsersRepo) Update(ctx context.Context, user *User) error {
+	query := `UPDATE users
+			SET name = ?, email = ?, password_hash = ?, activated = ?, version = version + 1
+			WHERE id = ? AND version = ?
+			RETURNING version`
+
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return dbError(ctx, err)
+		}
+	}
+	return nil
+}
+
+func (repo SQLiteUsersRepo) GetForToken(ctx context.Context, scope string, token string) (*User, error) {
+	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+			FROM users INNER JOIN tokens ON users.id = tokens.user_id
+			WHERE tokens.scope = ? AND tokens.hash = ? AND tokens.expiry > ?`
+
+	hash := sha256.Sum256([]byte(token))
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	user, err := scanUser(repo.DB.QueryRowContext(ctx, query, scope, hash[:], time.Now().Unix()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, dbError(ctx, err)
+		}
+	}
+	return user, nil
+}
+
+type SQLiteTokensRepo struct {
+	DB       DBTX
+	Timeouts Timeouts
+}
+
+func (repo SQLiteTokensRepo) Insert(ctx context.Context, token *Token) error {
+	query := `INSERT INTO tokens (hash, user_id, expiry, scope)
+			VALUES (?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry.Unix(), token.Scope)
+	return nameForeignKey(dbError(ctx, err), "tokens_user_id_fkey", "tokens")
+}
+
+func (repo SQLiteTokensRepo) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, fmt.Errorf("generate token: %w", err)
+	}
+	// A user holds at most one token of each scope.
+	err = repo.DeleteAllForUser(ctx, scope, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = repo.Insert(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (repo SQLiteTokensRepo) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	query := `DELETE FROM tokens WHERE scope = ? AND user_id = ?`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, query, scope, userID)
+	return dbError(ctx, err)
+}
+
+type SQLiteIdempotencyRepo struct {
+	DB       DBTX
+	Timeouts Timeouts
+}
+
+func (repo SQLiteIdempotencyRepo) Reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	for {
+		existing, err := repo.reserve(ctx, record)
+		// The key was held when the insert ran but released before it could be read,
+		// so it may be free now. Every retry follows a release by another request.
+		if !errors.Is(err, sql.ErrNoRows) {
+			return existing, err
+		}
+		if ctx.Err() != nil {
+			return nil, dbError(ctx, ctx.Err())
+		}
+	}
+}
+
+func (repo SQLiteIdempotencyRepo) reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	query := `INSERT INTO idempotency_keys (client, key, method, path, fingerprint, expiry)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (client, key, method, path) DO UPDATE
+				SET fingerprint = excluded.fingerprint, status = NULL, headers = NULL, body = NULL,
+					created_at = CAST(strftime('%s', 'now') AS integer), expiry = excluded.expiry
+				WHERE idempotency_keys.expiry <= CAST(strftime('%s', 'now') AS integer)
+			RETURNING key`
+
+	args := []interface{}{record.Client, record.Key, record.Method, record.Path, record.Fingerprint, record.Expiry.Unix()}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	var key string
+	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&key)
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, dbError(ctx, err)
+	}
+
+	query = `SELECT fingerprint, status, headers, body, expiry
+			FROM idempotency_keys
+			WHERE client = ? AND key = ? AND method = ? AND path = ?`
+
+	var (
+		existing = IdempotencyRecord{Client: record.Client, Key: record.Key, Method: record.Method, Path: record.Path}
+		status   sql.NullInt64
+		headers  sql.NullString
+		expiry   int64
+	)
+	err = repo.DB.QueryRowContext(ctx, query, record.Client, record.Key, record.Method, record.Path).Scan(
+		&existing.Fingerprint,
+		&status,
+		&headers,
+		&existing.Body,
+		&expiry,
+	)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	existing.Status = int(status.Int64)
+	existing.Expiry = time.Unix(expiry, 0)
+	if headers.Valid {
+		err = json.Unmarshal([]byte(headers.String), &existing.Headers)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+	}
+	return &existing, nil
+}
+
+func (repo SQLiteIdempotencyRepo) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	query := `UPDATE idempotency_keys
+			SET status = ?, headers = ?, body = ?
+			WHERE client = ? AND key = ? AND method = ? AND path = ?`
+
+	headers, err := json.Marshal(record.Headers)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	args := []interface{}{record.Status, string(headers), record.Body, record.Client, record.Key, record.Method, record.Path}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	_, err = repo.DB.ExecContext(ctx, query, args...)
+	return dbError(ctx, err)
+}
+
+func (repo SQLiteIdempotencyRepo) Release(ctx context.Context, record *IdempotencyRecord) error {
+	query := `DELETE FROM idempotency_keys
+			WHERE client = ? AND key = ? AND method = ? AND path = ? AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, query, record.Client, record.Key, record.Method, record.Path)
+	return dbError(ctx, err)
+}
+
+func (repo SQLiteIdempotencyRepo) DeleteExpired(ctx context.Context) error {
+	query := `DELETE FROM idempotency_keys WHERE expiry <= CAST(strftime('%s', 'now') AS integer)`
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, query)
+	return dbError(ctx, err)
+}
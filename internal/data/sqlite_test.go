@@ -0,0 +1,90 @@
+//go:build sqlite
+
+package data_test
+
+import (
+	"context"
+	"database/sql"
+	_ "modernc.org/sqlite"
+	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/data/datatest"
+	"simplewebapi.moviedb/internal/migrate"
+	"simplewebapi.moviedb/migrations"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSQLiteRepo runs the contract suite against a fresh in-memory SQLite database
+// per test. It is only built with the sqlite tag.
+func TestSQLiteRepo(t *testing.T) {
+	datatest.Run(t, func(t *testing.T) data.Repo {
+		return data.NewSQLiteRepo(openSQLite(t), data.DefaultTimeouts)
+	})
+}
+
+// releasingDB deletes every idempotency record right before the first read of a
+// stored record, as a concurrent Release would after the insert of Reserve conflicted.
+type releasingDB struct {
+	data.DBTX
+	released bool
+}
+
+func (db *releasingDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if !db.released && strings.HasPrefix(strings.TrimSpace(query), "SELECT") {
+		db.released = true
+		_, err := db.DBTX.ExecContext(ctx, `DELETE FROM idempotency_keys`)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return db.DBTX.QueryRowContext(ctx, query, args...)
+}
+
+func TestSQLiteReserveReleased(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	record := &data.IdempotencyRecord{Client: "alice", Key: "key", Method: "POST", Path: "/movies",
+		Fingerprint: data.Fingerprint("POST", "/movies", nil), Expiry: time.Now().Add(time.Hour)}
+	_, err := data.SQLiteIdempotencyRepo{DB: db, Timeouts: data.DefaultTimeouts}.Reserve(ctx, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released := &releasingDB{DBTX: db}
+	existing, err := data.SQLiteIdempotencyRepo{DB: released, Timeouts: data.DefaultTimeouts}.Reserve(ctx, record)
+	if !released.released {
+		t.Fatal("Reserve did not read the conflicting record")
+	}
+	if err != nil || existing != nil {
+		t.Errorf("Reserve of a key released before it was read = %+v, %v; want nil, nil", existing, err)
+	}
+}
+
+// openSQLite opens a fresh, migrated in-memory SQLite database.
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Every connection to :memory: opens a different database.
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxIdleTime(0)
+
+	_, err = db.Exec(`PRAGMA foreign_keys = ON`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(db, migrations.SQLiteFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Dialect = migrate.SQLite
+	err = m.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
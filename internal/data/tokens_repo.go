@@ -2,54 +2,61 @@ package data
 
 import (
 	"context"
-	"database/sql"
+	"fmt"
 	"time"
 )
 
 type TokensRepoInterface interface {
-	Insert(token *Token) error
-	New(userID int64, ttl time.Duration, scope string) (*Token, error)
-	DeleteAllForUser(scope string, userID int64) error
+	Insert(ctx context.Context, token *Token) error
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
 }
 
 type TokensRepo struct {
-	DB *sql.DB
+	DB       DBTX
+	Timeouts Timeouts
 }
 
-func (repo TokensRepo) Insert(token *Token) error {
+func (repo TokensRepo) Insert(ctx context.Context, token *Token) error {
 	query := `INSERT INTO tokens (hash,user_id, expiry,scope )
 			VALUES ($1,$2,$3,$4)`
 
 	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
 	defer cancel()
 
 	_, err := repo.DB.ExecContext(ctx, query, args...)
-	return err
+	return dbError(ctx, err)
 }
 
-func (repo TokensRepo) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (repo TokensRepo) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, fmt.Errorf("generate token: %w", err)
+	}
+	// A user holds at most one token of each scope.
+	err = repo.DeleteAllForUser(ctx, scope, userID)
 	if err != nil {
 		return nil, err
 	}
-	err = repo.DeleteAllForUser(ScopeAuthentication, userID)
-	err = repo.Insert(token)
-
-	return token, err
+	err = repo.Insert(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
 }
 
-func (repo TokensRepo) DeleteAllForUser(scope string, userID int64) error {
+func (repo TokensRepo) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `DELETE FROM tokens
 			WHERE scope like $1 AND user_id = $2 `
 	args := []interface{}{scope, userID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
 	defer cancel()
 
 	_, err := repo.DB.ExecContext(ctx, query, args...)
 
-	return err
+	return dbError(ctx, err)
 
 }
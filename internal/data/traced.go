@@ -0,0 +1,211 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"simplewebapi.moviedb/internal/trace"
+	"time"
+)
+
+// Traced returns a copy of repo whose repositories record a child span of the span
+// held by the call's context around every call, including calls made inside WithTx.
+// system names the database behind repo in the db.system attribute of the spans, such
+// as "postgresql" or "sqlite".
+func Traced(tracer *trace.Tracer, system string, repo Repo) Repo {
+	if !tracer.Enabled() {
+		return repo
+	}
+	t := tracedRepo{tracer: tracer, system: system}
+	decorate := repo.decorate
+	repo = t.wrap(repo)
+	repo.decorate = func(r Repo) Repo {
+		if decorate != nil {
+			r = decorate(r)
+		}
+		return t.wrap(r)
+	}
+	return repo
+}
+
+func (t tracedRepo) wrap(repo Repo) Repo {
+	repo.Movies = tracedMovies{t, repo.Movies}
+	repo.Users = tracedUsers{t, repo.Users}
+	repo.Tokens = tracedTokens{t, repo.Tokens}
+	repo.Idempotency = tracedIdempotency{t, repo.Idempotency}
+	return repo
+}
+
+type tracedRepo struct {
+	tracer *trace.Tracer
+	system string
+}
+
+func (t tracedRepo) start(ctx context.Context, name, operation, table string) (context.Context, *trace.Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.KindClient)
+	span.SetAttr("db.system", t.system)
+	span.SetAttr("db.operation", operation)
+	span.SetAttr("db.sql.table", table)
+	return ctx, span
+}
+
+// finish ends span, recording the number of rows involved unless it is negative (unknown)
+// and treating the sentinel errors of this package as outcomes rather than failures.
+func finish(span *trace.Span, rows int, err error) {
+	switch {
+	case err == nil && rows >= 0:
+		span.SetAttr("db.rows", rows)
+	case err == nil:
+	case errors.Is(err, ErrRecordNotFound), errors.Is(err, ErrEditConflict), errors.Is(err, ErrDuplicateEmail),
+		errors.Is(err, ErrCanceled), errors.As(err, new(*ConstraintError)):
+		span.SetAttr("db.rows", 0)
+		span.SetAttr("db.outcome", err.Error())
+	default:
+		span.RecordError(err)
+	}
+	span.Finish()
+}
+
+type tracedMovies struct {
+	tracedRepo
+	next MoviesRepoInterface
+}
+
+func (m tracedMovies) Insert(ctx context.Context, movie *Movie) error {
+	ctx, span := m.start(ctx, "MoviesRepo.Insert", "INSERT", "movies")
+	err := m.next.Insert(ctx, movie)
+	finish(span, 1, err)
+	return err
+}
+
+func (m tracedMovies) Get(ctx context.Context, id int64) (*Movie, error) {
+	ctx, span := m.start(ctx, "MoviesRepo.Get", "SELECT", "movies")
+	movie, err := m.next.Get(ctx, id)
+	finish(span, 1, err)
+	return movie, err
+}
+
+func (m tracedMovies) GetAll(ctx context.Context, title string, genres []string, filter Filter) ([]*Movie, Metadata, error) {
+	ctx, span := m.start(ctx, "MoviesRepo.GetAll", "SELECT", "movies")
+	span.SetAttr("db.filter.title", title)
+	span.SetAttr("db.filter.page", filter.Page)
+	span.SetAttr("db.filter.page_size", filter.PageSize)
+	movies, metadata, err := m.next.GetAll(ctx, title, genres, filter)
+	finish(span, len(movies), err)
+	return movies, metadata, err
+}
+
+func (m tracedMovies) Update(ctx context.Context, movie *Movie) error {
+	ctx, span := m.start(ctx, "MoviesRepo.Update", "UPDATE", "movies")
+	err := m.next.Update(ctx, movie)
+	finish(span, 1, err)
+	return err
+}
+
+func (m tracedMovies) Delete(ctx context.Context, id int64) error {
+	ctx, span := m.start(ctx, "MoviesRepo.Delete", "DELETE", "movies")
+	err := m.next.Delete(ctx, id)
+	finish(span, 1, err)
+	return err
+}
+
+func (m tracedMovies) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	ctx, span := m.start(ctx, "MoviesRepo.DeleteVersion", "DELETE", "movies")
+	err := m.next.DeleteVersion(ctx, id, version)
+	finish(span, 1, err)
+	return err
+}
+
+type tracedUsers struct {
+	tracedRepo
+	next UsersRepoInterface
+}
+
+func (u tracedUsers) Insert(ctx context.Context, user *User) error {
+	ctx, span := u.start(ctx, "UsersRepo.Insert", "INSERT", "users")
+	err := u.next.Insert(ctx, user)
+	finish(span, 1, err)
+	return err
+}
+
+func (u tracedUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := u.start(ctx, "UsersRepo.GetByEmail", "SELECT", "users")
+	user, err := u.next.GetByEmail(ctx, email)
+	finish(span, 1, err)
+	return user, err
+}
+
+func (u tracedUsers) Update(ctx context.Context, user *User) error {
+	ctx, span := u.start(ctx, "UsersRepo.Update", "UPDATE", "users")
+	err := u.next.Update(ctx, user)
+	finish(span, 1, err)
+	return err
+}
+
+func (u tracedUsers) GetForToken(ctx context.Context, scope string, token string) (*User, error) {
+	ctx, span := u.start(ctx, "UsersRepo.GetForToken", "SELECT", "users")
+	span.SetAttr("token.scope", scope)
+	user, err := u.next.GetForToken(ctx, scope, token)
+	finish(span, 1, err)
+	return user, err
+}
+
+type tracedTokens struct {
+	tracedRepo
+	next TokensRepoInterface
+}
+
+func (t tracedTokens) Insert(ctx context.Context, token *Token) error {
+	ctx, span := t.start(ctx, "TokensRepo.Insert", "INSERT", "tokens")
+	err := t.next.Insert(ctx, token)
+	finish(span, 1, err)
+	return err
+}
+
+func (t tracedTokens) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	ctx, span := t.start(ctx, "TokensRepo.New", "INSERT", "tokens")
+	span.SetAttr("token.scope", scope)
+	token, err := t.next.New(ctx, userID, ttl, scope)
+	finish(span, 1, err)
+	return token, err
+}
+
+func (t tracedTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	ctx, span := t.start(ctx, "TokensRepo.DeleteAllForUser", "DELETE", "tokens")
+	span.SetAttr("token.scope", scope)
+	err := t.next.DeleteAllForUser(ctx, scope, userID)
+	finish(span, -1, err)
+	return err
+}
+
+type tracedIdempotency struct {
+	tracedRepo
+	next IdempotencyRepoInterface
+}
+
+func (i tracedIdempotency) Reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	ctx, span := i.start(ctx, "IdempotencyRepo.Reserve", "INSERT", "idempotency_keys")
+	existing, err := i.next.Reserve(ctx, record)
+	finish(span, 1, err)
+	return existing, err
+}
+
+func (i tracedIdempotency) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	ctx, span := i.start(ctx, "IdempotencyRepo.Complete", "UPDATE", "idempotency_keys")
+	err := i.next.Complete(ctx, record)
+	finish(span, 1, err)
+	return err
+}
+
+func (i tracedIdempotency) Release(ctx context.Context, record *IdempotencyRecord) error {
+	ctx, span := i.start(ctx, "IdempotencyRepo.Release", "DELETE", "idempotency_keys")
+	err := i.next.Release(ctx, record)
+	finish(span, -1, err)
+	return err
+}
+
+func (i tracedIdempotency) DeleteExpired(ctx context.Context) error {
+	ctx, span := i.start(ctx, "IdempotencyRepo.DeleteExpired", "DELETE", "idempotency_keys")
+	err := i.next.DeleteExpired(ctx)
+	finish(span, -1, err)
+	return err
+}
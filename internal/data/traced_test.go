@@ -0,0 +1,58 @@
+package data_test
+
+import (
+	"context"
+	"simplewebapi.moviedb/internal/data"
+	"simplewebapi.moviedb/internal/trace"
+	"sync"
+	"testing"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.Span
+}
+
+func (r *spanRecorder) Export(_ context.Context, spans []*trace.Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(context.Context) error { return nil }
+
+func TestTraced(t *testing.T) {
+	ctx := context.Background()
+	recorder := &spanRecorder{}
+	tracer := trace.NewTracer(recorder, nil)
+	repo := data.Traced(tracer, "sqlite", data.NewMemoryRepo())
+
+	movie := &data.Movie{Title: "Alien", Year: 1979, Runtime: 117, Genres: []string{"sci-fi"}}
+	err := repo.WithTx(ctx, func(tx data.Repo) error {
+		return tx.Movies.Insert(ctx, movie)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.Movies.Get(ctx, 42)
+	if err == nil {
+		t.Fatal("Get of a missing movie succeeded")
+	}
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.spans) != 2 {
+		t.Fatalf("recorded %d spans; want 2", len(recorder.spans))
+	}
+	for _, span := range recorder.spans {
+		if span.Attributes["db.system"] != "sqlite" {
+			t.Errorf("span %s has db.system %v; want sqlite", span.Name, span.Attributes["db.system"])
+		}
+	}
+	get := recorder.spans[1]
+	if get.Name != "MoviesRepo.Get" || get.Status == trace.StatusError || get.Attributes["db.outcome"] == nil {
+		t.Errorf("a missing movie was not recorded as an outcome: %+v", get)
+	}
+}
@@ -32,8 +32,9 @@ type password struct {
 	hash      []byte
 }
 
-func (p *password) Set(raw string) error {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(raw), 11)
+// Set hashes raw with the given bcrypt cost.
+func (p *password) Set(raw string, cost int) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(raw), cost)
 	if err != nil {
 		return err
 	}
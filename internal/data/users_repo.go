@@ -9,22 +9,23 @@ import (
 )
 
 type UsersRepoInterface interface {
-	Insert(user *User) error
-	GetByEmail(email string) (*User, error)
-	Update(user *User) error
-	GetForToken(scope string, token string) (*User, error)
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, scope string, token string) (*User, error)
 }
 
 type UsersRepo struct {
-	DB *sql.DB
+	DB       DBTX
+	Timeouts Timeouts
 }
 
-func (repo UsersRepo) Insert(user *User) error {
+func (repo UsersRepo) Insert(ctx context.Context, user *User) error {
 	query := `INSERT INTO users (name, email, password_hash)
 			VALUES ($1,$2,$3)
 			RETURNING id, created_at,activated,version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
 	defer cancel()
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash}
@@ -32,21 +33,15 @@ func (repo UsersRepo) Insert(user *User) error {
 	err := repo.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Activated, &user.Version)
 
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		default:
-			return err
-		}
-
+		return dbError(ctx, err)
 	}
 	return nil
 }
 
-func (repo UsersRepo) GetByEmail(email string) (*User, error) {
+func (repo UsersRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `SELECT id,name,email,password_hash,activated,version FROM users
 			WHERE email= $1`
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Read)
 	defer cancel()
 	var user User
 	args := []interface{}{email}
@@ -61,18 +56,18 @@ func (repo UsersRepo) GetByEmail(email string) (*User, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, dbError(ctx, err)
 		}
 	}
 	return &user, nil
 }
 
-func (repo UsersRepo) Update(user *User) error {
+func (repo UsersRepo) Update(ctx context.Context, user *User) error {
 	query := `UPDATE users
 			SET name=$1,email=$2,password_hash=$3,activated=$4,version =version+1
 			WHERE id = $5 AND version = $6
 			RETURNING version`
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Write)
 	defer cancel()
 	args := []interface{}{
 		user.Name,
@@ -86,18 +81,16 @@ func (repo UsersRepo) Update(user *User) error {
 
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return dbError(ctx, err)
 		}
 	}
 	return nil
 }
 
-func (repo UsersRepo) GetForToken(scope string, token string) (*User, error) {
+func (repo UsersRepo) GetForToken(ctx context.Context, scope string, token string) (*User, error) {
 	query := `SELECT users.id,users.created_at,users.name,users.email,users.password_hash, users.activated, 
 users.version 
 			FROM users INNER JOIN tokens on users.id = tokens.user_id
@@ -105,7 +98,7 @@ users.version
 	hash := sha256.Sum256([]byte(token))
 
 	args := []interface{}{scope, hash[:], time.Now()}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeouts.Read)
 	defer cancel()
 
 	var user User
@@ -124,7 +117,7 @@ users.version
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, dbError(ctx, err)
 		}
 	}
 	return &user, nil
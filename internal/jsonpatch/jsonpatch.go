@@ -0,0 +1,371 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned when the patch document itself is malformed.
+	ErrInvalidPatch = errors.New("invalid patch document")
+	// ErrApplyFailed is returned when a well-formed patch cannot be applied to the target document.
+	ErrApplyFailed = errors.New("patch could not be applied")
+	// ErrTestFailed is returned when a "test" operation does not match the target document.
+	ErrTestFailed = errors.New("patch test operation failed")
+)
+
+// Operation is a single operation of a patch. Value is empty when the operation has no
+// "value" member, and holds the literal null when the member is null.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type Patch []Operation
+
+// DecodePatch parses an RFC 6902 JSON Patch document.
+func DecodePatch(b []byte) (Patch, error) {
+	var p Patch
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+	for i, op := range p {
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return nil, fmt.Errorf("%w: operation %d (%s) is missing \"value\"", ErrInvalidPatch, i, op.Op)
+			}
+		case "move", "copy":
+			if _, err := parsePointer(op.From); err != nil {
+				return nil, fmt.Errorf("%w: operation %d: %s", ErrInvalidPatch, i, err)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%w: operation %d has unknown op %q", ErrInvalidPatch, i, op.Op)
+		}
+		if _, err := parsePointer(op.Path); err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %s", ErrInvalidPatch, i, err)
+		}
+	}
+	return p, nil
+}
+
+// Apply runs every operation against doc in order and returns the patched document.
+// The patch is atomic: if any operation fails, doc is left untouched and an error is returned.
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	root, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range p {
+		root, err = p.apply(root, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func (p Patch) apply(root interface{}, op Operation) (interface{}, error) {
+	path, _ := parsePointer(op.Path)
+
+	switch op.Op {
+	case "add":
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	case "remove":
+		root, _, err := remove(root, path)
+		return root, err
+	case "replace":
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		root, _, err = remove(root, path)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	case "move":
+		from, _ := parsePointer(op.From)
+		if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+			return nil, fmt.Errorf("%w: cannot move a value into one of its children", ErrApplyFailed)
+		}
+		root, value, err := remove(root, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	case "copy":
+		from, _ := parsePointer(op.From)
+		value, err := get(root, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, deepCopy(value))
+	case "test":
+		expected, err := decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := get(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(actual, expected) {
+			return nil, ErrTestFailed
+		}
+		return root, nil
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op.Op)
+}
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to doc.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = merge(targetObj[key], value)
+	}
+	return targetObj
+}
+
+func decode(b []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("json pointer %q must start with \"/\"", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrApplyFailed, token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrApplyFailed, token)
+	}
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if i < 0 || i > max {
+		return 0, fmt.Errorf("%w: array index %d out of bounds", ErrApplyFailed, i)
+	}
+	return i, nil
+}
+
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			value, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q does not exist", ErrApplyFailed, token)
+			}
+			node = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("%w: cannot traverse into a scalar value at %q", ErrApplyFailed, token)
+		}
+	}
+	return node, nil
+}
+
+func add(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = value
+		return root, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(p), true)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, nil)
+		copy(p[i+1:], p[i:])
+		p[i] = value
+		return set(root, path[:len(path)-1], p)
+	}
+	return nil, fmt.Errorf("%w: cannot add a member to a scalar value", ErrApplyFailed)
+}
+
+func remove(root interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, root, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		value, ok := p[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: member %q does not exist", ErrApplyFailed, last)
+		}
+		delete(p, last)
+		return root, value, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(p), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := p[i]
+		p = append(p[:i:i], p[i+1:]...)
+		root, err = set(root, path[:len(path)-1], p)
+		return root, value, err
+	}
+	return nil, nil, fmt.Errorf("%w: cannot remove a member from a scalar value", ErrApplyFailed)
+}
+
+// set replaces the value found at path, which is needed because slices grow and shrink by value.
+func set(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = value
+	case []interface{}:
+		i, err := arrayIndex(last, len(p), false)
+		if err != nil {
+			return nil, err
+		}
+		p[i] = value
+	}
+	return root, nil
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(n))
+		for k, val := range n {
+			c[k] = deepCopy(val)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(n))
+		for i, val := range n {
+			c[i] = deepCopy(val)
+		}
+		return c
+	}
+	return v
+}
+
+// equal compares two decoded JSON values, treating numbers as equal when they have the
+// same value however they are written, such as 1 and 1.0.
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aerr := a.Float64()
+		bf, berr := b.Float64()
+		if aerr == nil && berr == nil {
+			return af == bf
+		}
+		return a == b
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			other, ok := b[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
@@ -0,0 +1,148 @@
+package jsonpatch
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error
+	}{
+		{"add member", `{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": "qux"}]`, `{"baz": "qux", "foo": "bar"}`, nil},
+		{"add null", `{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": null}]`, `{"baz": null, "foo": "bar"}`, nil},
+		{"add array element", `{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo": ["bar", "qux", "baz"]}`, nil},
+		{"add to the end of an array", `{"foo": [1, 2]}`, `[{"op": "add", "path": "/foo/-", "value": 3}]`, `{"foo": [1, 2, 3]}`, nil},
+		{"add replaces the root", `{"foo": 1}`, `[{"op": "add", "path": "", "value": [1]}]`, `[1]`, nil},
+		{"add past the end", `{"foo": [1]}`, `[{"op": "add", "path": "/foo/2", "value": 3}]`, "", ErrApplyFailed},
+		{"add to a missing parent", `{}`, `[{"op": "add", "path": "/a/b", "value": 1}]`, "", ErrApplyFailed},
+		{"add with a leading zero index", `{"foo": [1, 2]}`, `[{"op": "add", "path": "/foo/01", "value": 3}]`, "", ErrApplyFailed},
+
+		{"remove member", `{"baz": "qux", "foo": "bar"}`, `[{"op": "remove", "path": "/baz"}]`, `{"foo": "bar"}`, nil},
+		{"remove array element", `{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo": ["bar", "baz"]}`, nil},
+		{"remove a missing member", `{"foo": "bar"}`, `[{"op": "remove", "path": "/baz"}]`, "", ErrApplyFailed},
+		{"remove the end of an array", `{"foo": [1]}`, `[{"op": "remove", "path": "/foo/-"}]`, "", ErrApplyFailed},
+
+		{"replace", `{"baz": "qux", "foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": "boo"}]`, `{"baz": "boo", "foo": "bar"}`, nil},
+		{"replace with null", `{"foo": "bar"}`, `[{"op": "replace", "path": "/foo", "value": null}]`, `{"foo": null}`, nil},
+		{"replace a missing member", `{"foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": 1}]`, "", ErrApplyFailed},
+
+		{"move member", `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			`[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			`{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`, nil},
+		{"move array element", `{"foo": ["all", "grass", "cows", "eat"]}`,
+			`[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, `{"foo": ["all", "cows", "eat", "grass"]}`, nil},
+		{"move into a child", `{"a": {"b": {}}}`, `[{"op": "move", "from": "/a", "path": "/a/b/c"}]`, "", ErrApplyFailed},
+		{"move a missing member", `{}`, `[{"op": "move", "from": "/a", "path": "/b"}]`, "", ErrApplyFailed},
+
+		{"copy", `{"foo": {"bar": 1}}`, `[{"op": "copy", "from": "/foo", "path": "/baz"}, {"op": "add", "path": "/baz/bar", "value": 2}]`,
+			`{"baz": {"bar": 2}, "foo": {"bar": 1}}`, nil},
+		{"copy a missing member", `{}`, `[{"op": "copy", "from": "/a", "path": "/b"}]`, "", ErrApplyFailed},
+
+		{"test string", `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			`[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`, nil},
+		{"test null", `{"foo": null}`, `[{"op": "test", "path": "/foo", "value": null}]`, `{"foo": null}`, nil},
+		{"test number formats", `{"foo": {"bar": [1, 2.50]}}`, `[{"op": "test", "path": "/foo", "value": {"bar": [1.0, 25e-1]}}]`,
+			`{"foo": {"bar": [1, 2.50]}}`, nil},
+		{"test mismatch", `{"baz": "qux"}`, `[{"op": "test", "path": "/baz", "value": "bar"}]`, "", ErrTestFailed},
+		{"test a number against a string", `{"foo": "1"}`, `[{"op": "test", "path": "/foo", "value": 1}]`, "", ErrTestFailed},
+		{"test an object with more members", `{"foo": {"a": 1, "b": 2}}`, `[{"op": "test", "path": "/foo", "value": {"a": 1}}]`, "", ErrTestFailed},
+		{"test a missing member", `{}`, `[{"op": "test", "path": "/foo", "value": 1}]`, "", ErrApplyFailed},
+
+		{"escaped tokens", `{"a/b": 1, "m~n": 2}`,
+			`[{"op": "test", "path": "/a~1b", "value": 1}, {"op": "replace", "path": "/m~0n", "value": 3}, {"op": "add", "path": "/~01", "value": 4}]`,
+			`{"a/b": 1, "m~n": 3, "~1": 4}`, nil},
+		{"traverse a scalar", `{"foo": 1}`, `[{"op": "add", "path": "/foo/bar", "value": 1}]`, "", ErrApplyFailed},
+		{"failed test discards earlier operations", `{"foo": 1}`,
+			`[{"op": "replace", "path": "/foo", "value": 2}, {"op": "test", "path": "/foo", "value": 1}]`, "", ErrTestFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := DecodePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := patch.Apply([]byte(tt.doc))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got %s, %v; want %v", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jsonEqual(t, got, []byte(tt.want)) {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"not an array", `{"op": "remove", "path": "/a"}`},
+		{"unknown op", `[{"op": "frobnicate", "path": "/a"}]`},
+		{"unknown member", `[{"op": "remove", "path": "/a", "extra": 1}]`},
+		{"add without value", `[{"op": "add", "path": "/a"}]`},
+		{"replace without value", `[{"op": "replace", "path": "/a"}]`},
+		{"test without value", `[{"op": "test", "path": "/a"}]`},
+		{"path without slash", `[{"op": "remove", "path": "a"}]`},
+		{"from without slash", `[{"op": "move", "from": "a", "path": "/b"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodePatch([]byte(tt.patch))
+			if !errors.Is(err, ErrInvalidPatch) {
+				t.Errorf("got %v; want ErrInvalidPatch", err)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`["a", "b"]`, `{"a": "c"}`, `{"a": "c"}`},
+	}
+
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !jsonEqual(t, got, []byte(tt.want)) {
+			t.Errorf("MergePatch(%s, %s) = %s; want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+// jsonEqual reports whether a and b hold the same JSON value, whatever their layout.
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	av, err := decode(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bv, err := decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return equal(av, bv)
+}
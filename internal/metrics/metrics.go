@@ -0,0 +1,329 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are latency buckets in seconds suited to an HTTP API.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics and renders them in the Prometheus text exposition format.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (reg *Registry) register(c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.collectors = append(reg.collectors, c)
+}
+
+func (reg *Registry) Write(w io.Writer) error {
+	reg.mu.Lock()
+	collectors := append([]collector(nil), reg.collectors...)
+	reg.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.Write(w)
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+func (d desc) labelString(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range d.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, escapeLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extra[i], escapeLabel(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// vec stores one value per combination of label values.
+type vec[T any] struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*T
+	keys   map[string][]string
+	newT   func() *T
+}
+
+func (v *vec[T]) with(values ...string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	t, ok := v.values[key]
+	if !ok {
+		t = v.newT()
+		v.values[key] = t
+		v.keys[key] = append([]string(nil), values...)
+	}
+	return t
+}
+
+func (v *vec[T]) each(fn func(values []string, t *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]*T, len(keys))
+	labels := make([][]string, len(keys))
+	for i, key := range keys {
+		entries[i] = v.values[key]
+		labels[i] = v.keys[key]
+	}
+	v.mu.Unlock()
+
+	for i := range entries {
+		fn(labels[i], entries[i])
+	}
+}
+
+func newVec[T any](name, help, kind string, labels []string, newT func() *T) *vec[T] {
+	return &vec[T]{
+		desc:   desc{name: name, help: help, kind: kind, labels: labels},
+		values: make(map[string]*T),
+		keys:   make(map[string][]string),
+		newT:   newT,
+	}
+}
+
+// Value is a float64 that can be updated concurrently.
+type Value struct {
+	bits atomic.Uint64
+}
+
+func (v *Value) Add(delta float64) {
+	for {
+		old := v.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if v.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (v *Value) Set(val float64) {
+	v.bits.Store(math.Float64bits(val))
+}
+
+func (v *Value) Get() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+func (v *Value) Inc() { v.Add(1) }
+func (v *Value) Dec() { v.Add(-1) }
+
+type CounterVec struct{ v *vec[Value] }
+
+// NewCounter registers a monotonically increasing counter partitioned by the given labels.
+func (reg *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, "counter", labels, func() *Value { return &Value{} })}
+	reg.register(c)
+	return c
+}
+
+func (c *CounterVec) With(values ...string) *Value {
+	return c.v.with(values...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeValues(w, c.v)
+}
+
+type GaugeVec struct{ v *vec[Value] }
+
+func (reg *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{v: newVec(name, help, "gauge", labels, func() *Value { return &Value{} })}
+	reg.register(g)
+	return g
+}
+
+func (g *GaugeVec) With(values ...string) *Value {
+	return g.v.with(values...)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	writeValues(w, g.v)
+}
+
+func writeValues(w *bufio.Writer, v *vec[Value]) {
+	v.writeHeader(w)
+	v.each(func(values []string, val *Value) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelString(values), formatFloat(val.Get()))
+	})
+}
+
+// funcMetric reads its value from a callback each time the registry is scraped.
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+func (reg *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	reg.register(&funcMetric{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+func (reg *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	reg.register(&funcMetric{desc: desc{name: name, help: help, kind: "counter"}, fn: fn})
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	m.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(m.fn()))
+}
+
+// NewInfo registers a gauge that is always 1 and carries its information in labels,
+// like the conventional build_info metric.
+func (reg *Registry) NewInfo(name, help string, labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+	reg.NewGauge(name, help, names...).With(values...).Set(1)
+}
+
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) Observe(val float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if val <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += val
+	h.count++
+}
+
+type Observer interface {
+	Observe(val float64)
+}
+
+type HistogramVec struct {
+	v       *vec[histogram]
+	buckets []float64
+}
+
+func (reg *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{
+		v: newVec(name, help, "histogram", labels, func() *histogram {
+			return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		}),
+		buckets: buckets,
+	}
+	reg.register(h)
+	return h
+}
+
+func (h *HistogramVec) With(values ...string) Observer {
+	return h.v.with(values...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	d := h.v.desc
+	d.writeHeader(w)
+	h.v.each(func(values []string, hist *histogram) {
+		hist.mu.Lock()
+		counts := append([]uint64(nil), hist.counts...)
+		sum, count := hist.sum, hist.count
+		hist.mu.Unlock()
+
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", d.name, d.labelString(values, "le", formatFloat(upper)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", d.name, d.labelString(values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", d.name, d.labelString(values), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", d.name, d.labelString(values), count)
+	})
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
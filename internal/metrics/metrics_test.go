@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata instead of comparing against them")
+
+func TestRegistryWrite(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := reg.NewCounter("http_requests_total", "Requests served.", "method", "status")
+	requests.With("GET", "200").Add(3)
+	requests.With("POST", "201").Inc()
+	requests.With("GET", "404").Inc()
+
+	inFlight := reg.NewGauge("http_requests_in_flight", "Requests being served.")
+	inFlight.With().Inc()
+	inFlight.With().Inc()
+	inFlight.With().Dec()
+
+	escaped := reg.NewGauge("escaped", "Help with a backslash \\ and a\nnewline.", "label")
+	escaped.With("quote \" backslash \\ newline \n").Set(math.Inf(1))
+
+	reg.NewGaugeFunc("goroutines", "Number of goroutines.", func() float64 { return 7 })
+	reg.NewCounterFunc("bytes_total", "Bytes written.", func() float64 { return 1.5e9 })
+	reg.NewInfo("build_info", "Build information.", map[string]string{"version": "1.0.0", "go_version": "go1.24"})
+
+	latency := reg.NewHistogram("http_request_duration_seconds", "Request latency.", []float64{1, 0.1, 0.5}, "route")
+	for _, v := range []float64{0.05, 0.2, 0.2, 0.7, 3} {
+		latency.With("GET /v1/movies").Observe(v)
+	}
+	reg.NewHistogram("empty_seconds", "A histogram without observations.", []float64{1})
+
+	var b bytes.Buffer
+	err := reg.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join("testdata", "registry.golden")
+	if *update {
+		err := os.MkdirAll("testdata", 0o755)
+		if err == nil {
+			err = os.WriteFile(path, b.Bytes(), 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run the tests with -update to create it", err)
+	}
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("output does not match %s\n--- got\n%s\n--- want\n%s", path, b.Bytes(), want)
+	}
+}
@@ -0,0 +1,368 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrDirty          = errors.New("database is dirty, fix the failed migration and force a version")
+	ErrNoChange       = errors.New("no change")
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// lockKey identifies the advisory lock held while migrating, so that replicas
+// starting at the same time do not apply the same migration twice.
+var lockKey = int64(crc32.ChecksumIEEE([]byte("simplewebapi.moviedb/schema_migrations")))
+
+var filenameRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+var placeholderRX = regexp.MustCompile(`\$\d+`)
+
+// Dialect selects the SQL understood by the database being migrated.
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	SQLite
+)
+
+// bind rewrites the $N placeholders of query for the dialect. Every placeholder must
+// appear once and in order.
+func (d Dialect) bind(query string) string {
+	if d == SQLite {
+		return placeholderRX.ReplaceAllString(query, "?")
+	}
+	return query
+}
+
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+// Load reads the NNNNNN_name.up.sql / NNNNNN_name.down.sql pairs found at the root of fsys.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		m := filenameRX.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if m[3] == "up" {
+			mig.up = string(body)
+		} else {
+			mig.down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// LatestVersion returns the highest migration version found in fsys.
+func LatestVersion(fsys fs.FS) (int64, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// Migrator applies migrations and records the current version in a schema_migrations
+// table laid out like golang-migrate's, so databases migrated by hand keep working.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	Log        func(format string, args ...interface{})
+	Dialect    Dialect
+}
+
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, Log: func(string, ...interface{}) {}}, nil
+}
+
+type Status struct {
+	Version    int64
+	Dirty      bool
+	Migrations []MigrationStatus
+}
+
+type MigrationStatus struct {
+	Version int64
+	Name    string
+	Applied bool
+}
+
+// Status reports the current version and which known migrations have been applied.
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	var status Status
+	err := m.withConn(ctx, false, func(conn *sql.Conn) error {
+		var err error
+		status.Version, status.Dirty, err = m.version(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return Status{}, err
+	}
+	for _, mig := range m.migrations {
+		status.Migrations = append(status.Migrations, MigrationStatus{
+			Version: mig.Version,
+			Name:    mig.Name,
+			Applied: mig.Version <= status.Version,
+		})
+	}
+	return status, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return ErrNoChange
+	}
+	latest := m.migrations[len(m.migrations)-1].Version
+	return m.withConn(ctx, true, func(conn *sql.Conn) error {
+		current, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+		// A database migrated by a newer build is left alone rather than rolled back.
+		if current >= latest {
+			return ErrNoChange
+		}
+		return m.migrateTo(ctx, conn, current, latest)
+	})
+}
+
+// Down rolls back the given number of migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withConn(ctx, true, func(conn *sql.Conn) error {
+		current, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+		target := int64(0)
+		applied := m.appliedUpTo(current)
+		if steps < len(applied) {
+			target = applied[len(applied)-steps-1].Version
+		}
+		return m.migrateTo(ctx, conn, current, target)
+	})
+}
+
+// Goto migrates up or down until the schema is at version.
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return m.withConn(ctx, true, func(conn *sql.Conn) error {
+		current, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+		return m.migrateTo(ctx, conn, current, version)
+	})
+}
+
+// Force records version as the current one and clears the dirty flag without running
+// any migration. It is meant for recovering from a migration that failed halfway.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return m.withConn(ctx, true, func(conn *sql.Conn) error {
+		return m.setVersion(ctx, conn, version, false)
+	})
+}
+
+func (m *Migrator) migrateTo(ctx context.Context, conn *sql.Conn, current, target int64) error {
+	if current == target {
+		return ErrNoChange
+	}
+	if target > current {
+		for _, mig := range m.migrations {
+			if mig.Version <= current || mig.Version > target {
+				continue
+			}
+			m.Log("applying migration %d_%s", mig.Version, mig.Name)
+			err := m.run(ctx, conn, mig.Version, mig.up, mig.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", mig.Version, mig.Name, err)
+			}
+		}
+		return nil
+	}
+
+	applied := m.appliedUpTo(current)
+	for i := len(applied) - 1; i >= 0 && applied[i].Version > target; i-- {
+		mig := applied[i]
+		previous := int64(0)
+		if i > 0 {
+			previous = applied[i-1].Version
+		}
+		m.Log("reverting migration %d_%s", mig.Version, mig.Name)
+		err := m.run(ctx, conn, mig.Version, mig.down, previous)
+		if err != nil {
+			return fmt.Errorf("migration %d_%s down: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// run executes one migration in a transaction. The version is marked dirty first, so
+// that if the process dies in the middle the failure is visible on the next start.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, version int64, query string, newVersion int64) error {
+	err := m.setVersion(ctx, conn, version, true)
+	if err != nil {
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if query != "" {
+		_, err = tx.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+	}
+	err = m.writeVersion(ctx, tx, newVersion, false)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) appliedUpTo(version int64) []Migration {
+	var applied []Migration
+	for _, mig := range m.migrations {
+		if mig.Version <= version {
+			applied = append(applied, mig)
+		}
+	}
+	return applied
+}
+
+func (m *Migrator) find(version int64) int {
+	for i, mig := range m.migrations {
+		if mig.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// withConn runs fn on a single connection, creating the schema_migrations table if needed
+// and, when lock is set, holding the migration advisory lock for the duration of fn.
+// SQLite has no advisory locks; its database file is only ever opened by one process.
+func (m *Migrator) withConn(ctx context.Context, lock bool, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if lock && m.Dialect == Postgres {
+		_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL PRIMARY KEY,
+		dirty boolean NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) version(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	var (
+		version int64
+		dirty   bool
+	)
+	err := conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+func (m *Migrator) setVersion(ctx context.Context, conn *sql.Conn, version int64, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.writeVersion(ctx, tx, version, dirty)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) writeVersion(ctx context.Context, tx *sql.Tx, version int64, dirty bool) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	// Version 0 means that no migration is applied, which is recorded as an empty table.
+	if version == 0 && !dirty {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, m.Dialect.bind(`INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`), version, dirty)
+	return err
+}
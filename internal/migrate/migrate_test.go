@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000002_add_index.up.sql":      {Data: []byte("CREATE INDEX i ON t (a);")},
+		"000002_add_index.down.sql":    {Data: []byte("DROP INDEX i;")},
+		"000001_create_table.up.sql":   {Data: []byte("CREATE TABLE t (a int);")},
+		"000010_no_down.up.sql":        {Data: []byte("SELECT 1;")},
+		"README.md":                    {Data: []byte("not a migration")},
+		"000003_bad_suffix.sideways":   {Data: []byte("SELECT 1;")},
+		"sqlite/000001_other.up.sql":   {Data: []byte("SELECT 1;")},
+		"000001_create_table.down.sql": {Data: []byte("DROP TABLE t;")},
+	}
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "create_table", up: "CREATE TABLE t (a int);", down: "DROP TABLE t;"},
+		{Version: 2, Name: "add_index", up: "CREATE INDEX i ON t (a);", down: "DROP INDEX i;"},
+		{Version: 10, Name: "no_down", up: "SELECT 1;"},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("got %d migrations; want %d", len(migrations), len(want))
+	}
+	for i := range want {
+		if migrations[i] != want[i] {
+			t.Errorf("migration %d = %+v; want %+v", i, migrations[i], want[i])
+		}
+	}
+
+	latest, err := LatestVersion(fsys)
+	if err != nil || latest != 10 {
+		t.Errorf("LatestVersion = %d, %v; want 10", latest, err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	query := `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`
+	if got := Postgres.bind(query); got != query {
+		t.Errorf("Postgres.bind changed the query to %q", got)
+	}
+	if got, want := SQLite.bind(query), `INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`; got != want {
+		t.Errorf("SQLite.bind = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+//go:build sqlite
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	_ "modernc.org/sqlite"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestMigrator returns a Migrator for fsys working on a fresh in-memory SQLite
+// database. It is only built with the sqlite tag.
+func newTestMigrator(t *testing.T, fsys fstest.MapFS) (*Migrator, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Every connection to :memory: opens a different database.
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxIdleTime(0)
+
+	m, err := New(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Dialect = SQLite
+	return m, db
+}
+
+var testMigrations = fstest.MapFS{
+	"000001_create_movies.up.sql":   {Data: []byte("CREATE TABLE movies (id integer PRIMARY KEY, title text NOT NULL);")},
+	"000001_create_movies.down.sql": {Data: []byte("DROP TABLE movies;")},
+	"000002_add_year.up.sql":        {Data: []byte("ALTER TABLE movies ADD COLUMN year integer;")},
+	"000002_add_year.down.sql":      {Data: []byte("ALTER TABLE movies DROP COLUMN year;")},
+	"000003_create_users.up.sql":    {Data: []byte("CREATE TABLE users (id integer PRIMARY KEY);")},
+	"000003_create_users.down.sql":  {Data: []byte("DROP TABLE users;")},
+}
+
+func checkStatus(t *testing.T, m *Migrator, version int64, dirty bool) {
+	t.Helper()
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != version || status.Dirty != dirty {
+		t.Errorf("status = version %d, dirty %t; want %d, %t", status.Version, status.Dirty, version, dirty)
+	}
+	for _, mig := range status.Migrations {
+		if mig.Applied != (mig.Version <= version) {
+			t.Errorf("migration %d applied = %t at version %d", mig.Version, mig.Applied, version)
+		}
+	}
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	m, db := newTestMigrator(t, testMigrations)
+	checkStatus(t, m, 0, false)
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, m, 3, false)
+	if !tableExists(t, db, "users") {
+		t.Error("Up did not create the users table")
+	}
+	if err := m.Up(ctx); !errors.Is(err, ErrNoChange) {
+		t.Errorf("second Up: got %v; want ErrNoChange", err)
+	}
+
+	if err := m.Down(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, m, 1, false)
+	if tableExists(t, db, "users") {
+		t.Error("Down did not drop the users table")
+	}
+	if _, err := db.Exec(`INSERT INTO movies (title, year) VALUES ('Alien', 1979)`); err == nil {
+		t.Error("Down did not drop the year column")
+	}
+
+	if err := m.Goto(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, m, 2, false)
+	if err := m.Goto(ctx, 2); !errors.Is(err, ErrNoChange) {
+		t.Errorf("Goto the current version: got %v; want ErrNoChange", err)
+	}
+	if err := m.Goto(ctx, 7); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Goto an unknown version: got %v; want ErrUnknownVersion", err)
+	}
+
+	if err := m.Goto(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, m, 0, false)
+	if tableExists(t, db, "movies") {
+		t.Error("Goto 0 did not drop the movies table")
+	}
+}
+
+func TestMigratorFailure(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"000001_create_movies.up.sql": {Data: []byte("CREATE TABLE movies (id integer PRIMARY KEY);")},
+		"000002_broken.up.sql":        {Data: []byte("CREATE TABLE genres (id integer PRIMARY KEY); ALTER TABLE missing ADD COLUMN x integer;")},
+		"000002_broken.down.sql":      {Data: []byte("DROP TABLE genres;")},
+	}
+	m, db := newTestMigrator(t, fsys)
+
+	if err := m.Up(ctx); err == nil {
+		t.Fatal("Up succeeded with a broken migration")
+	}
+	// The failed migration is rolled back, and the version it was applying left dirty.
+	checkStatus(t, m, 2, true)
+	if !tableExists(t, db, "movies") {
+		t.Error("the migration before the broken one was not kept")
+	}
+	if tableExists(t, db, "genres") {
+		t.Error("the broken migration was not rolled back")
+	}
+
+	for name, run := range map[string]func() error{
+		"Up":   func() error { return m.Up(ctx) },
+		"Down": func() error { return m.Down(ctx, 1) },
+		"Goto": func() error { return m.Goto(ctx, 1) },
+	} {
+		if err := run(); !errors.Is(err, ErrDirty) {
+			t.Errorf("%s on a dirty database: got %v; want ErrDirty", name, err)
+		}
+	}
+
+	if err := m.Force(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, m, 1, false)
+	if err := m.Force(ctx, 5); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Force an unknown version: got %v; want ErrUnknownVersion", err)
+	}
+}
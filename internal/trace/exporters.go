@@ -0,0 +1,223 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter ships finished spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
+
+// WriterExporter writes one JSON object per span to an io.Writer, such as stdout
+// or a file, which is convenient for local testing.
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+type jsonSpan struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_span_id,omitempty"`
+	Name       string                 `json:"name"`
+	Kind       SpanKind               `json:"kind"`
+	Start      time.Time              `json:"start"`
+	Duration   string                 `json:"duration"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Status     StatusCode             `json:"status,omitempty"`
+	Message    string                 `json:"status_message,omitempty"`
+}
+
+func (e *WriterExporter) Export(_ context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		s.mu.Lock()
+		js := jsonSpan{
+			TraceID:    s.Context.TraceID.String(),
+			SpanID:     s.Context.SpanID.String(),
+			Name:       s.Name,
+			Kind:       s.Kind,
+			Start:      s.Start,
+			Duration:   s.End.Sub(s.Start).String(),
+			Attributes: s.Attributes,
+			Status:     s.Status,
+			Message:    s.StatusMessage,
+		}
+		if s.Parent.IsValid() {
+			js.ParentID = s.Parent.String()
+		}
+		err := enc.Encode(js)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *WriterExporter) Shutdown(_ context.Context) error {
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP/HTTP with
+// the JSON encoding, e.g. to http://localhost:4318/v1/traces.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    StatusCode `json:"code,omitempty"`
+		Message string     `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	res := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		var v otlpValue
+		switch val := attrs[k].(type) {
+		case string:
+			v.StringValue = &val
+		case bool:
+			v.BoolValue = &val
+		case int:
+			s := strconv.Itoa(val)
+			v.IntValue = &s
+		case int32:
+			s := strconv.FormatInt(int64(val), 10)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(val, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &val
+		default:
+			s := fmt.Sprint(val)
+			v.StringValue = &s
+		}
+		res = append(res, otlpAttribute{Key: k, Value: v})
+	}
+	return res
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []*Span) error {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		os := otlpSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.Parent.IsValid() {
+			os.ParentSpanID = s.Parent.String()
+		}
+		os.Status.Code = s.Status
+		os.Status.Message = s.StatusMessage
+		s.mu.Unlock()
+		out = append(out, os)
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": e.serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": e.serviceName},
+						"spans": out,
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp exporter: collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(_ context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,298 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+type Span struct {
+	mu sync.Mutex
+
+	tracer *Tracer
+	ended  bool
+
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	Status        StatusCode
+	StatusMessage string
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Name = name
+	s.mu.Unlock()
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed. Errors that are part of normal control
+// flow, like a missing record, should be recorded as attributes instead.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Status = StatusError
+	s.StatusMessage = err.Error()
+	s.mu.Unlock()
+}
+
+// Finish ends the span and hands it to the tracer's exporter. Calling it twice has no effect.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanContextKey struct{}
+type remoteContextKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent stores a span context received from another service,
+// so that the next span started from ctx continues that trace.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteContextKey{}, sc)
+}
+
+// ParseTraceparent decodes a W3C Trace Context traceparent header.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 == 1
+	return sc, true
+}
+
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// Tracer creates spans and exports finished ones in batches from a background goroutine.
+type Tracer struct {
+	exporter Exporter
+	spans    chan *Span
+	flush    chan chan struct{}
+	done     chan struct{}
+	onError  func(error)
+}
+
+const (
+	batchSize     = 128
+	queueSize     = 2048
+	flushInterval = 5 * time.Second
+)
+
+// NewTracer returns a tracer exporting through exporter. Export errors are passed to
+// onError, which may be nil. A nil exporter yields a tracer that records nothing.
+func NewTracer(exporter Exporter, onError func(error)) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		spans:    make(chan *Span, queueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+		onError:  onError,
+	}
+	if exporter != nil {
+		go t.run()
+	}
+	return t
+}
+
+func (t *Tracer) Enabled() bool {
+	return t != nil && t.exporter != nil
+}
+
+// Start begins a span as a child of the span or remote parent held by ctx.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if !t.Enabled() {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer:     t,
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+	switch parent := SpanFromContext(ctx); {
+	case parent != nil:
+		span.Context.TraceID = parent.Context.TraceID
+		span.Context.Sampled = parent.Context.Sampled
+		span.Parent = parent.Context.SpanID
+	default:
+		if remote, ok := ctx.Value(remoteContextKey{}).(SpanContext); ok {
+			span.Context.TraceID = remote.TraceID
+			span.Context.Sampled = remote.Sampled
+			span.Parent = remote.SpanID
+		} else {
+			rand.Read(span.Context.TraceID[:])
+			span.Context.Sampled = true
+		}
+	}
+	rand.Read(span.Context.SpanID[:])
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.spans <- s:
+	default:
+		// The queue is full, so the span is dropped rather than blocking a request.
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := t.exporter.Export(ctx, batch)
+		cancel()
+		if err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ack := <-t.flush:
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			export()
+			close(ack)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Shutdown exports every queued span and stops the tracer.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if !t.Enabled() {
+		return nil
+	}
+	ack := make(chan struct{})
+	select {
+	case t.flush <- ack:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	close(t.done)
+	return t.exporter.Shutdown(ctx)
+}
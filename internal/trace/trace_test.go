@@ -0,0 +1,121 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, header := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	} {
+		sc, ok := ParseTraceparent(header)
+		if !ok {
+			t.Fatalf("ParseTraceparent(%q) failed", header)
+		}
+		if got := FormatTraceparent(sc); got != header {
+			t.Errorf("FormatTraceparent(ParseTraceparent(%q)) = %q", header, got)
+		}
+	}
+
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Errorf("parsed %+v", sc)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", true, true},
+		// Other flags than sampled are ignored.
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-02", true, false},
+		// Later versions may append fields.
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01", false, false},
+		{"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.header)
+		if ok != tt.ok || sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceparent(%q) = %+v, %t; want ok %t, sampled %t", tt.header, sc, ok, tt.ok, tt.sampled)
+		}
+	}
+}
+
+// recordingExporter keeps the spans it is given.
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *recordingExporter) Export(_ context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(context.Context) error { return nil }
+
+func TestTracer(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter, nil)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemoteParent(context.Background(), remote)
+	ctx, server := tracer.Start(ctx, "GET /v1/movies", KindServer)
+	_, client := tracer.Start(ctx, "MoviesRepo.GetAll", KindClient)
+	client.SetAttr("db.system", "postgresql")
+	client.Finish()
+	client.Finish()
+	server.Finish()
+
+	_, unsampled := tracer.Start(ContextWithRemoteParent(context.Background(), SpanContext{
+		TraceID: remote.TraceID, SpanID: remote.SpanID}), "unsampled", KindServer)
+	unsampled.Finish()
+
+	err := tracer.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exporter.spans) != 2 {
+		t.Fatalf("exported %d spans; want 2", len(exporter.spans))
+	}
+	if server.Context.TraceID != remote.TraceID || server.Parent != remote.SpanID {
+		t.Errorf("server span %s/%s does not continue the remote trace", server.Context.TraceID, server.Parent)
+	}
+	if client.Context.TraceID != remote.TraceID || client.Parent != server.Context.SpanID {
+		t.Errorf("client span is not a child of the server span")
+	}
+	if client.Attributes["db.system"] != "postgresql" {
+		t.Errorf("client span attributes = %v", client.Attributes)
+	}
+}
+
+func TestDisabledTracer(t *testing.T) {
+	tracer := NewTracer(nil, nil)
+	ctx, span := tracer.Start(context.Background(), "noop", KindInternal)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Error("a tracer without an exporter started a span")
+	}
+	// Spans of a disabled tracer are nil, and their methods must not panic.
+	span.SetAttr("key", "value")
+	span.Finish()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// FS holds the SQL migrations so that the binary can apply them without the source tree.
+//
+//go:embed *.sql
+var FS embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+// SQLiteFS holds the same migrations written for SQLite. A version only needed by one
+// database is missing from the other set, so versions may have gaps.
+var SQLiteFS, _ = fs.Sub(sqliteFiles, "sqlite")
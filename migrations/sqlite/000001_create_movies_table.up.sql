@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS movies (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at integer NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS integer)),
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text NOT NULL CHECK (json_type(genres) = 'array'),
+    version integer NOT NULL DEFAULT 1
+);
@@ -0,0 +1,12 @@
+-- SQLite cannot add a CHECK constraint to an existing table, so triggers enforce
+-- the same rules as movies_runtime_check and movies_year_check.
+CREATE TRIGGER IF NOT EXISTS movies_check_insert BEFORE INSERT ON movies
+WHEN NEW.runtime < 0 OR NEW.year NOT BETWEEN 1888 AND CAST(strftime('%Y', 'now') AS integer)
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_check');
+END;
+CREATE TRIGGER IF NOT EXISTS movies_check_update BEFORE UPDATE ON movies
+WHEN NEW.runtime < 0 OR NEW.year NOT BETWEEN 1888 AND CAST(strftime('%Y', 'now') AS integer)
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_check');
+END;
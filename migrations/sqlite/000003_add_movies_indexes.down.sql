@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS movies_fts_insert;
+DROP TRIGGER IF EXISTS movies_fts_delete;
+DROP TRIGGER IF EXISTS movies_fts_update;
+DROP TABLE IF EXISTS movies_fts;
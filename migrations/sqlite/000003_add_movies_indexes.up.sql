@@ -0,0 +1,20 @@
+-- movies_fts replaces the GIN index on to_tsvector('simple', title). The genres
+-- array is searched with json_each and needs no index at this scale.
+CREATE VIRTUAL TABLE IF NOT EXISTS movies_fts USING fts5(
+    title,
+    content = 'movies',
+    content_rowid = 'id',
+    tokenize = 'unicode61'
+);
+INSERT INTO movies_fts (rowid, title) SELECT id, title FROM movies;
+
+CREATE TRIGGER IF NOT EXISTS movies_fts_insert AFTER INSERT ON movies BEGIN
+    INSERT INTO movies_fts (rowid, title) VALUES (NEW.id, NEW.title);
+END;
+CREATE TRIGGER IF NOT EXISTS movies_fts_delete AFTER DELETE ON movies BEGIN
+    INSERT INTO movies_fts (movies_fts, rowid, title) VALUES ('delete', OLD.id, OLD.title);
+END;
+CREATE TRIGGER IF NOT EXISTS movies_fts_update AFTER UPDATE OF title ON movies BEGIN
+    INSERT INTO movies_fts (movies_fts, rowid, title) VALUES ('delete', OLD.id, OLD.title);
+    INSERT INTO movies_fts (rowid, title) VALUES (NEW.id, NEW.title);
+END;
@@ -0,0 +1,11 @@
+-- COLLATE NOCASE stands in for citext: emails are unique and compared ignoring case.
+CREATE TABLE IF NOT EXISTS users (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at integer NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS integer)),
+    name text NOT NULL,
+    email text NOT NULL COLLATE NOCASE,
+    password_hash blob NOT NULL,
+    activated integer NOT NULL DEFAULT 1,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT users_email_key UNIQUE (email)
+);
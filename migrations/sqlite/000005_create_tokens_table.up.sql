@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    hash blob PRIMARY KEY,
+    user_id integer REFERENCES users ON DELETE CASCADE,
+    expiry integer NOT NULL,
+    scope text NOT NULL
+);
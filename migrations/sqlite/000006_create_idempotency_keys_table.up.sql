@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    client text NOT NULL DEFAULT '',
+    key text NOT NULL,
+    method text NOT NULL,
+    path text NOT NULL,
+    fingerprint blob NOT NULL,
+    status integer,
+    headers text,
+    body blob,
+    created_at integer NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS integer)),
+    expiry integer NOT NULL,
+    PRIMARY KEY (client, key, method, path)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expiry_idx ON idempotency_keys (expiry);
@@ -0,0 +1,3 @@
+-- SQLite only accepts constant defaults in ADD COLUMN, so inserts set updated_at themselves.
+ALTER TABLE movies ADD COLUMN updated_at integer NOT NULL DEFAULT 0;
+UPDATE movies SET updated_at = created_at;
@@ -0,0 +1,14 @@
+DROP TRIGGER IF EXISTS movies_runtime_check_insert;
+DROP TRIGGER IF EXISTS movies_runtime_check_update;
+DROP TRIGGER IF EXISTS movies_year_check_insert;
+DROP TRIGGER IF EXISTS movies_year_check_update;
+CREATE TRIGGER IF NOT EXISTS movies_check_insert BEFORE INSERT ON movies
+WHEN NEW.runtime < 0 OR NEW.year NOT BETWEEN 1888 AND CAST(strftime('%Y', 'now') AS integer)
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_check');
+END;
+CREATE TRIGGER IF NOT EXISTS movies_check_update BEFORE UPDATE ON movies
+WHEN NEW.runtime < 0 OR NEW.year NOT BETWEEN 1888 AND CAST(strftime('%Y', 'now') AS integer)
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_check');
+END;
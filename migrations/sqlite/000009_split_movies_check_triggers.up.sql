@@ -0,0 +1,24 @@
+-- Replace the single trigger pair of 000002 with one pair per rule, raising errors that
+-- name movies_runtime_check and movies_year_check like the PostgreSQL constraints.
+DROP TRIGGER IF EXISTS movies_check_insert;
+DROP TRIGGER IF EXISTS movies_check_update;
+CREATE TRIGGER IF NOT EXISTS movies_runtime_check_insert BEFORE INSERT ON movies
+WHEN NEW.runtime < 0
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_runtime_check');
+END;
+CREATE TRIGGER IF NOT EXISTS movies_runtime_check_update BEFORE UPDATE ON movies
+WHEN NEW.runtime < 0
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_runtime_check');
+END;
+CREATE TRIGGER IF NOT EXISTS movies_year_check_insert BEFORE INSERT ON movies
+WHEN NEW.year NOT BETWEEN 1888 AND CAST(strftime('%Y', 'now') AS integer)
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_year_check');
+END;
+CREATE TRIGGER IF NOT EXISTS movies_year_check_update BEFORE UPDATE ON movies
+WHEN NEW.year NOT BETWEEN 1888 AND CAST(strftime('%Y', 'now') AS integer)
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_year_check');
+END;